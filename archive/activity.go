@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the signals which tell that a dataset is in use, the modify time of the files is always counted
+const (
+	SignalMtime     = "mtime"     // modify time of the files
+	SignalCtime     = "ctime"     // change time of the files, it's also set when files are restored with old modify times
+	SignalAtime     = "atime"     // access time of the files, if the file system records it
+	SignalKeepalive = "keepalive" // modify time of the .keepalive file of the dataset
+	SignalFeed      = "feed"      // last access times read from the activity-feed file
+)
+
+var ActivitySignals = []string{SignalMtime, SignalCtime, SignalAtime, SignalKeepalive, SignalFeed}
+
+// the file touched by the users to keep a dataset, it only counts with the keepalive signal
+const KeepaliveFileName = ".keepalive"
+
+// Activity is the last time a dataset is used, and the signal which tells it
+type Activity struct {
+	Time   time.Time
+	Signal string
+}
+
+// update the activity if t is later
+func (a *Activity) observe(t time.Time, signal string) {
+	if t.After(a.Time) {
+		a.Time = t
+		a.Signal = signal
+	}
+}
+
+// return the problems of a list of signals
+func activityProblems(signals []string) []string {
+	problems := make([]string, 0)
+	for _, signal := range signals {
+		if !containsString(ActivitySignals, signal) {
+			problems = append(problems, fmt.Sprintf("activity %s is not one of [%s]", signal, strings.Join(ActivitySignals, ", ")))
+		}
+	}
+	return problems
+}
+
+// the change and access time of a file, zero if they are not known
+func fileTimes(info fs.FileInfo) (ctime time.Time, atime time.Time) {
+	if st, ok := info.Sys().(*memStat); ok {
+		return st.ctime, st.atime
+	}
+	return sysFileTimes(info)
+}
+
+// ActivityFeed is the last access time of the paths listed in the activity-feed file,
+// e.g. written from the access logs of a file server. Every folder gets the latest time of the paths inside it.
+type ActivityFeed map[string]time.Time
+
+// ReadActivityFeed reads the activity-feed file.
+// Every line is a RFC 3339 time and a path separated by a space, empty lines and lines starting with # are skipped.
+func ReadActivityFeed(path string) (ActivityFeed, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open the activity feed")
+	}
+	defer f.Close()
+	feed := make(ActivityFeed)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("line %d of the activity feed has no path", n)
+		}
+		t, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d of the activity feed", n)
+		}
+		feed.add(strings.TrimSpace(fields[1]), t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "can't read the activity feed")
+	}
+	return feed, nil
+}
+
+func (f ActivityFeed) add(path string, t time.Time) {
+	path = filepath.Clean(path)
+	for {
+		if t.After(f[path]) {
+			f[path] = t
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return
+		}
+		path = parent
+	}
+}
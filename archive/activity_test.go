@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestActivitySignals(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 10, 1, 2, 0, 0, 0, time.Local))
+	fsys := NewMemFS(clock)
+	day := func(d int) time.Time { return clock.Now().AddDate(0, 0, d) }
+	fsys.AddFile("/storage/ds/frames/1.tif", []byte("1"), day(-40))
+	fsys.AddFile("/storage/ds/frames/2.tif", []byte("2"), day(-40))
+	fsys.Access("/storage/ds/frames/1.tif", day(-3))
+	fsys.Access("/storage/ds/frames", day(0)) // listed by the scan
+	fsys.AddFile("/storage/ds/"+KeepaliveFileName, nil, day(-1))
+	clock.Set(day(-5))
+	fsys.AddFile("/storage/ds/frames/restored.tif", []byte("r"), day(-5))
+	fsys.Chtimes("/storage/ds/frames/restored.tif", day(-40)) // cp -p
+	fsys.Chtimes("/storage/ds/frames", day(-40))
+	fsys.Chtimes("/storage/ds", day(-40))
+	clock.Set(day(5))
+	e := &Engine{Config: DefaultConfig(), FS: fsys, Clock: clock}
+
+	cases := []struct {
+		signals  []string
+		expected Activity
+	}{
+		{nil, Activity{day(-40), SignalMtime}},
+		{[]string{SignalCtime}, Activity{day(-5), SignalCtime}},
+		{[]string{SignalAtime}, Activity{day(-3), SignalAtime}},
+		{[]string{SignalCtime, SignalKeepalive}, Activity{day(-1), SignalKeepalive}},
+	}
+	for _, c := range cases {
+		activity, _, _, err := e.scanUpdateTime("/storage/ds", &Policy{Activity: c.signals}, time.Time{})
+		if err != nil || !activity.Time.Equal(c.expected.Time) || activity.Signal != c.expected.Signal {
+			t.Errorf("signals %v: got %v %s, expected %v %s, %v", c.signals, activity.Time, activity.Signal, c.expected.Time, c.expected.Signal, err)
+		}
+	}
+
+	feedFile := filepath.Join(t.TempDir(), "feed")
+	ioutil.WriteFile(feedFile, []byte("# last access\n"+day(-2).Format(time.RFC3339)+" /storage/ds/frames/1.tif\n"), 0644)
+	feed, err := ReadActivityFeed(feedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !feed["/storage/ds"].Equal(day(-2).Truncate(time.Second)) {
+		t.Errorf("the feed time is not given to the dataset folder: %v", feed["/storage/ds"])
+	}
+	ioutil.WriteFile(feedFile, []byte("yesterday /storage/ds\n"), 0644)
+	if _, err := ReadActivityFeed(feedFile); err == nil {
+		t.Error("a feed with a wrong time must not be read")
+	}
+}
@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+)
+
+// SelectForCapacity checks the disk usage of the roots in capacity mode.
+// If a root is used above its high watermark, the oldest and then largest datasets, which are unmodified
+// for capacity-min-age days and not on hold, get a capacity deadline, until the usage after archiving them
+// would be below the low watermark. They are archived at the deadline, the notices are sent on the way.
+// The roots whose usage can't be read are returned as errors.
+func (e *Engine) SelectForCapacity() []ScanError {
+	scanErrors := make([]ScanError, 0)
+	policies := e.Config.Policies()
+	var records []DatasetRecord
+	for _, policy := range policies {
+		if policy.CapacityHighWatermark == 0 {
+			continue
+		}
+		usage, err := e.FS.Usage(policy.Root)
+		if err != nil {
+			log.Printf("failed to read the disk usage of %s, error: %v", policy.Root, err)
+			scanErrors = append(scanErrors, ScanError{Root: policy.Root, Path: policy.Root, Msg: "failed to read the disk usage: " + err.Error()})
+			continue
+		}
+		if usage.UsedPercent() < float64(policy.CapacityHighWatermark) {
+			continue
+		}
+		if records == nil {
+			records, err = e.Store.ListActiveRecords()
+			if err != nil {
+				log.Printf("failed to list the records, error: %v", err)
+				scanErrors = append(scanErrors, ScanError{Root: policy.Root, Path: policy.Root, Msg: "failed to list the records: " + err.Error()})
+				return scanErrors
+			}
+		}
+		log.Printf("%s is %.1f%% full, select datasets to archive", policy.Root, usage.UsedPercent())
+		e.selectForCapacity(policy, policies, records, usage)
+	}
+	return scanErrors
+}
+
+func (e *Engine) selectForCapacity(policy *Policy, policies []*Policy, records []DatasetRecord, usage DiskUsage) {
+	used := usage.Total - usage.Free
+	target := usage.Total / 100 * uint64(policy.CapacityLowWatermark)
+	free := func(size int64) {
+		if uint64(size) > used {
+			used = 0
+		} else {
+			used -= uint64(size)
+		}
+	}
+	candidates := make([]DatasetRecord, 0)
+	for _, r := range records {
+		if policyOf(policies, r.Path) != policy {
+			continue
+		}
+		if r.CapacityDeadline.Valid { // already selected, count the space it will free
+			free(r.Stats.Bytes)
+			continue
+		}
+		if r.LastModifyTime.Valid { // the records not scanned yet are left out, their size is not known
+			candidates = append(candidates, r)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if !a.LastModifyTime.Time.Equal(b.LastModifyTime.Time) {
+			return a.LastModifyTime.Time.Before(b.LastModifyTime.Time)
+		}
+		return a.Stats.Bytes > b.Stats.Bytes
+	})
+	today := startOfDay(e.Clock.Now())
+	for _, r := range candidates {
+		if used <= target {
+			return
+		}
+		unchangeDays := int(today.Sub(startOfDay(r.LastModifyTime.Time)).Hours() / 24)
+		if unchangeDays < policy.CapacityMinAge {
+			continue
+		}
+		datasetPolicy := e.datasetPolicy(policy, r.Path)
+		if datasetPolicy.Hold != "" {
+			log.Printf("dataset %s, %s is on hold: %s", r.ID, r.Path, datasetPolicy.Hold)
+			continue
+		}
+		r.CapacityDeadline = sql.NullTime{
+			Time:  today.AddDate(0, 0, policy.CapacityNoticeDays),
+			Valid: true,
+		}
+		r.NoticedLeftDays = 0 // start the notices again on the shortened schedule
+		err := e.Store.UpdateRecord(&r)
+		if err != nil {
+			log.Printf("failed to update record %s, error: %v", r.ID, err)
+			continue
+		}
+		log.Printf("dataset %s, %s of %s will be archived on %s to free space", r.ID, r.Path, formatBytes(r.Stats.Bytes), r.CapacityDeadline.Time.Format("2006-01-02"))
+		free(r.Stats.Bytes)
+	}
+}
@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const smtpCheckTimeout = 10 * time.Second
+
+// CheckResult is the result of one check done by CheckEnvironment
+type CheckResult struct {
+	Name string
+	Err  error // nil if the check passed
+}
+
+// CheckEnvironment checks that the things the config refers to can be used:
+// the root folders, the database file, the commands and the smtp server.
+// Nothing is changed, no email is sent.
+func CheckEnvironment(config *AppConfig) []CheckResult {
+	results := make([]CheckResult, 0, 5)
+	policies := config.Policies()
+	if len(policies) == 0 {
+		results = append(results, CheckResult{Name: "root", Err: checkRoot("")})
+	}
+	for _, p := range policies {
+		results = append(results, CheckResult{Name: "root " + p.Root, Err: checkRoot(p.Root)})
+	}
+	results = append(results, CheckResult{Name: "db " + config.DB, Err: checkWritable(config.DB)})
+	for _, p := range policies {
+		suffix := "" // with several roots, tell which root the command belongs to
+		if len(policies) > 1 {
+			suffix = " " + p.Root
+		}
+		results = append(results, CheckResult{Name: "archive-command" + suffix, Err: checkExecutable(p.ArchiveCommand)})
+		if p.BackupCommand != "" {
+			results = append(results, CheckResult{Name: "backup-command" + suffix, Err: checkExecutable(p.BackupCommand)})
+		}
+	}
+	emailConfig := NewEmailNotifier(config).Config
+	results = append(results, CheckResult{Name: "smtp " + emailConfig.addr(), Err: checkSmtp(&emailConfig)})
+	return results
+}
+
+func checkRoot(root string) error {
+	if root == "" {
+		return errors.New("root is empty")
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.Errorf("%s is not a folder", root)
+	}
+	_, err = os.ReadDir(root)
+	return err
+}
+
+// check the database file can be written, or created if it doesn't exist
+func checkWritable(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".autoarchive-check-")
+	if err != nil {
+		return errors.Wrap(err, "the database doesn't exist and can't be created")
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// check the program of a command can be found
+func checkExecutable(command string) error {
+	if command == "" {
+		return errors.New("command is empty")
+	}
+	fields, err := getFields(command)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 || fields[0] == "" {
+		return errors.New("command is empty")
+	}
+	_, err = exec.LookPath(fields[0])
+	return err
+}
+
+// connect and log in to the smtp server the same way as sending a report
+func checkSmtp(c *EmailConfig) error {
+	conn, err := net.DialTimeout("tcp", c.addr(), smtpCheckTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpCheckTimeout))
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if err = client.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return errors.Wrap(err, "starttls failed")
+		}
+	}
+	if auth := c.auth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err = client.Auth(auth); err != nil {
+				return errors.Wrap(err, "authentication failed")
+			}
+		}
+	}
+	return client.Quit()
+}
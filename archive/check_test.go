@@ -0,0 +1,39 @@
+package archive
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rubenlab.org/autoarchive/internal/smtptest"
+)
+
+func TestCheckEnvironment(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Root = dir
+	config.DB = filepath.Join(dir, "archive.db")
+	config.ArchiveCommand = "rm -rf ${path}"
+	config.BackupCommand = "no-such-program-autoarchive ${file}"
+	config.SmtpHost = server.Host()
+	config.SmtpPort = server.Port()
+	config.SmtpUser = "archive@example.org"
+	failed := map[string]bool{}
+	for _, result := range CheckEnvironment(config) {
+		failed[strings.Fields(result.Name)[0]] = result.Err != nil
+	}
+	expected := map[string]bool{"root": false, "db": false, "archive-command": false, "backup-command": true, "smtp": false}
+	for name, fail := range expected {
+		if failed[name] != fail {
+			t.Errorf("check %s failed: %v, expected %v", name, failed[name], fail)
+		}
+	}
+	if len(server.Messages()) != 0 {
+		t.Errorf("check sent an email")
+	}
+}
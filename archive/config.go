@@ -0,0 +1,226 @@
+package archive
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const FileModeCreate = fs.FileMode(0640)
+const FolderModeCreate = fs.FileMode(0750)
+
+type AppConfig struct {
+	DB               string `yaml:"db"`
+	DBBackend        string `yaml:"db-backend"`         // one of StoreBackends, bolt by default
+	DBSnapshots      int    `yaml:"db-snapshots"`       // copies of the database kept, one is taken before every run
+	DBSnapshotFolder string `yaml:"db-snapshot-folder"` // folder of the copies, the snapshots folder next to db if empty
+	ServerName       string `yaml:"server-name"`        // server name for email report
+	Root             string // root path to scan, or use Roots for several roots
+	ScanLevel        int    `yaml:"scan-level"`         // If the scan depth reaches ScanLevel, force the directories to be marked as dataset
+	ScanInterval     int    `yaml:"scan-interval"`      // scan interval in days
+	ArchiveInterval  int    `yaml:"archive-interval"`   // archive interval in days
+	NoticeBefore     []int  `yaml:"notice-before"`      // how many days to notice before archive
+	EmailTo          string `yaml:"email-to"`           // comma separated, email to whom when folder will be archived
+	ArchiveCommand   string `yaml:"archive-command"`    // archive command, ${path} can be used.
+	BackupCommand    string `yaml:"backup-command"`     // archive command, ${id}, ${dir}, ${file}, ${date} can be used. example: cd ${dir} && tar --files-from=${file} --file=${id}/${date}/archive.tar
+	SmtpHost         string `yaml:"smtp-host"`          // smtp host address
+	SmtpPort         int    `yaml:"smtp-port"`          // smtp port
+	SmtpUser         string `yaml:"smtp-user"`          // smtp username
+	SmtpPassword     Secret `yaml:"smtp-password"`      // smtp password, better use smtp-password-file
+	SmtpPasswordFile string `yaml:"smtp-password-file"` // file containing the smtp password
+	LogFolder        string `yaml:"log-folder"`         // folder to write out logs
+	PidFile          string `yaml:"pid-file"`           // pid file
+	Cores            int    // workers discovering and scanning the datasets
+	WalkWorkers      int    `yaml:"walk-workers"` // goroutines walking the folders of one dataset
+	// a folder containing one of these folders is a dataset
+	CharacterFolders []string `yaml:"character-folders"`
+	// limits of the overrides in the .datasetinfo files, see RootConfig
+	MaxArchiveInterval int      `yaml:"max-archive-interval"`
+	AllowNoBackup      bool     `yaml:"allow-no-backup"`
+	BackupTargets      []string `yaml:"backup-targets"`
+	// capacity mode, see RootConfig
+	CapacityHighWatermark int `yaml:"capacity-high-watermark"`
+	CapacityLowWatermark  int `yaml:"capacity-low-watermark"`
+	CapacityMinAge        int `yaml:"capacity-min-age"`
+	CapacityNoticeDays    int `yaml:"capacity-notice-days"`
+	// signals which tell that a dataset is in use, in addition to the modify time of the files, see ActivitySignals
+	Activity     []string `yaml:"activity"`
+	ActivityFeed string   `yaml:"activity-feed"` // file with the last access times, for the feed signal
+	// a dataset folder inside another dataset, one of NestedModes, see RootConfig
+	NestedDatasets string `yaml:"nested-datasets"`
+	// days a dataset not found at its path is kept as missing, discovery may find it at a new path
+	MissingDays int `yaml:"missing-days"`
+	// follow the changes of the datasets in the watch daemon, instead of walking them at every scan
+	Watch bool `yaml:"watch"`
+	// the usage report is sent by the run of this day of the month, 0 turns it off
+	UsageReportDay int    `yaml:"usage-report-day"`
+	UsageReportTo  string `yaml:"usage-report-to"` // comma separated, email-to if empty
+	// several storage roots, each with its own policy, instead of a single Root.
+	// The values above are used for the roots which don't set them.
+	Roots []RootConfig `yaml:"roots"`
+}
+
+// DefaultConfig returns the configuration used when no config file is given,
+// the values of a config file are merged into it.
+func DefaultConfig() *AppConfig {
+	return &AppConfig{
+		DB:               "archive.db",
+		DBBackend:        BackendBolt,
+		DBSnapshots:      7,
+		ScanLevel:        3,
+		ScanInterval:     3,
+		ArchiveInterval:  30,
+		NoticeBefore:     []int{10, 5, 1},
+		SmtpHost:         "localhost", // will use local email server
+		SmtpPort:         25,
+		PidFile:          "/tmp/autoarchive.pid",
+		Cores:            4,
+		WalkWorkers:      4,
+		CharacterFolders: CharacterFolderNames[:],
+		// capacity mode is off until capacity-high-watermark is set
+		CapacityLowWatermark: 80,
+		CapacityMinAge:       14,
+		CapacityNoticeDays:   7,
+		MissingDays:          7,
+		NestedDatasets:       NestedParent,
+	}
+}
+
+// ValidationError lists all the problems found in a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d problem(s) in config:\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// LoadConfig reads a config file and merges it into the default config.
+// ${NAME} in the values is replaced by the environment variable NAME, ${NAME:-default} gives a default value
+// and $${ is written for a literal ${. The placeholders of the commands are kept.
+// If the file can be parsed but the config is invalid, the config is returned together with a *ValidationError.
+func LoadConfig(path string) (*AppConfig, error) {
+	config := DefaultConfig()
+	if path == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not open config file")
+	}
+	data, problems := interpolateYAML(data, os.LookupEnv)
+	err = yaml.UnmarshalStrict(data, config)
+	if typeErr, ok := err.(*yaml.TypeError); ok { // unknown keys or wrong types, the rest of the config is still read
+		problems = append(problems, typeErr.Errors...)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "can not unmarshal config data")
+	}
+	problems = append(problems, interpolateConfig(config, os.LookupEnv)...)
+	problems = append(problems, loadSecrets(config, os.LookupEnv)...)
+	problems = append(problems, config.problems()...)
+	if len(problems) > 0 {
+		return config, &ValidationError{Problems: problems}
+	}
+	return config, nil
+}
+
+// Validate checks the config values, all the problems found are returned in a *ValidationError
+func (c *AppConfig) Validate() error {
+	problems := c.problems()
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// SnapshotFolder is the folder of the database snapshots taken before the runs
+func (c *AppConfig) SnapshotFolder() string {
+	if c.DBSnapshotFolder != "" {
+		return c.DBSnapshotFolder
+	}
+	return filepath.Join(filepath.Dir(c.DB), "snapshots")
+}
+
+// BackupSocket is the unix socket of a run or the watch daemon copying the bolt database for db backup
+func (c *AppConfig) BackupSocket() string {
+	return c.DB + ".sock"
+}
+
+var archivePlaceholders = []string{"id", "path"}
+var backupPlaceholders = []string{"id", "dir", "file", "date", "target"}
+
+func (c *AppConfig) problems() []string {
+	problems := make([]string, 0)
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if c.DB == "" {
+		add("db is empty")
+	}
+	if !containsString(StoreBackends, c.DBBackend) {
+		add("db-backend %q is not one of [%s]", c.DBBackend, strings.Join(StoreBackends, ", "))
+	}
+	if c.DBSnapshots < 0 {
+		add("db-snapshots is %d, it must not be negative", c.DBSnapshots)
+	}
+	problems = append(problems, c.policyProblems()...)
+	if c.ActivityFeed == "" {
+		for _, p := range c.Policies() {
+			if containsString(p.Activity, SignalFeed) {
+				add("activity-feed is empty, the feed signal of %s can't be used", p.Root)
+			}
+		}
+	}
+	if c.Cores < 1 {
+		add("cores is %d, it must be at least 1", c.Cores)
+	}
+	if c.WalkWorkers < 1 {
+		add("walk-workers is %d, it must be at least 1", c.WalkWorkers)
+	}
+	if c.MissingDays < 0 {
+		add("missing-days is %d, it must not be negative", c.MissingDays)
+	}
+	if c.UsageReportDay < 0 || c.UsageReportDay > 28 {
+		add("usage-report-day is %d, it must be between 1 and 28, or 0 for no usage report", c.UsageReportDay)
+	}
+	if c.SmtpHost == "" {
+		add("smtp-host is empty")
+	}
+	if c.SmtpPort < 1 || c.SmtpPort > 65535 {
+		add("smtp-port %d is not a valid port", c.SmtpPort)
+	}
+	return problems
+}
+
+var placeholderPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// check a command can be split into fields, and only uses the known placeholders
+func checkCommandSyntax(command string, placeholders []string) error {
+	if _, err := getFields(command); err != nil {
+		return errors.Wrap(err, "can't split the command into arguments")
+	}
+	known := map[string]bool{}
+	for _, p := range placeholders {
+		known[p] = true
+	}
+	unknown := make([]string, 0)
+	for _, match := range placeholderPattern.FindAllStringSubmatch(command, -1) {
+		// upper case names are left for the shell, written as $${NAME} in the config
+		if !known[match[1]] && match[1] == strings.ToLower(match[1]) {
+			unknown = append(unknown, match[0])
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errors.Errorf("unknown placeholder(s) %s, only ${%s} can be used", strings.Join(unknown, ", "), strings.Join(placeholders, "}, ${"))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig("testdata/config-test.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := config.DB != "archive.db"
+	failed = failed || config.ScanLevel != 3
+	failed = failed || config.ArchiveInterval != 30
+	failed = failed || config.ArchiveCommand != "rm -rf \"${path}\""
+	failed = failed || config.LogFolder != "log"
+	failed = failed || config.Cores != 4
+	if failed {
+		t.Errorf("config value not correct: \n%v", config)
+	}
+}
+
+func TestLoadConfigMergesDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	data := "root: /storage\nemail-to: admin@example.org\narchive-command: \"rm -rf ${path}\"\narchive-interval: 60\n"
+	if err := ioutil.WriteFile(path, []byte(data), FileModeCreate); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ArchiveInterval != 60 || config.ScanLevel != 3 || config.Cores != 4 || len(config.NoticeBefore) != 3 || config.SmtpPort != 25 {
+		t.Errorf("defaults are not merged: %+v", config)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := func() *AppConfig {
+		c := DefaultConfig()
+		c.Root = "/storage"
+		c.EmailTo = "admin@example.org"
+		c.ArchiveCommand = "rm -rf ${path}"
+		c.BackupCommand = "tar --files-from=${file} --file=${id}/${date}.tar"
+		return c
+	}
+	cases := []struct {
+		name     string
+		modify   func(c *AppConfig)
+		problems []string
+	}{
+		{"valid", func(c *AppConfig) {}, nil},
+		{"missing root", func(c *AppConfig) { c.Root = "" }, []string{"root is empty"}},
+		{"zero cores", func(c *AppConfig) { c.Cores = 0 }, []string{"cores is 0, it must be at least 1"}},
+		{"notice longer than archive interval", func(c *AppConfig) { c.ArchiveInterval = 7; c.NoticeBefore = []int{10, 5} }, []string{
+			"notice-before contains 10, it must be shorter than archive-interval 7",
+		}},
+		{"empty archive command", func(c *AppConfig) { c.ArchiveCommand = "" }, []string{"archive-command is empty, expired datasets can't be archived"}},
+		{"unknown placeholder", func(c *AppConfig) { c.ArchiveCommand = "rm -rf ${dir}" }, []string{
+			"archive-command: unknown placeholder(s) ${dir}, only ${id}, ${path} can be used",
+		}},
+		{"several problems", func(c *AppConfig) { c.Root = ""; c.Cores = -1; c.SmtpPort = 0 }, []string{
+			"root is empty",
+			"cores is -1, it must be at least 1",
+			"smtp-port 0 is not a valid port",
+		}},
+		{"capacity watermarks", func(c *AppConfig) { c.CapacityHighWatermark = 70 }, []string{
+			"capacity-low-watermark is 80, it must be lower than capacity-high-watermark 70",
+		}},
+		{"activity signals", func(c *AppConfig) { c.Activity = []string{"ctime", "birth", "feed"} }, []string{
+			"activity birth is not one of [mtime, ctime, atime, keepalive, feed]",
+			"activity-feed is empty, the feed signal of /storage can't be used",
+		}},
+		{"nested datasets", func(c *AppConfig) { c.NestedDatasets = "outer" }, []string{
+			`nested-datasets "outer" is not one of [parent, child, error]`,
+		}},
+		{"database", func(c *AppConfig) { c.DBBackend = "postgres"; c.DBSnapshots = -1 }, []string{
+			`db-backend "postgres" is not one of [bolt, sqlite]`,
+			"db-snapshots is -1, it must not be negative",
+		}},
+		{"root and roots", func(c *AppConfig) { c.Roots = []RootConfig{{Path: "/other"}} }, []string{"root and roots can't be used together"}},
+		{"overlapping roots", func(c *AppConfig) {
+			c.Root = ""
+			c.Roots = []RootConfig{{Path: "/storage"}, {Path: "/storage/krios/", ArchiveInterval: 5}}
+		}, []string{
+			"roots[1] /storage/krios: overlaps with root /storage",
+			"roots[1] /storage/krios: notice-before contains 10, it must be shorter than archive-interval 5",
+			"roots[1] /storage/krios: notice-before contains 5, it must be shorter than archive-interval 5",
+		}},
+		{"root without recipients", func(c *AppConfig) {
+			c.Root = ""
+			c.EmailTo = ""
+			c.Roots = []RootConfig{{Path: "/storage/krios", EmailTo: "krios@example.org"}, {Path: "/storage/scratch"}}
+		}, []string{"roots[1] /storage/scratch: email-to is empty, reports can't be sent"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := valid()
+			c.modify(config)
+			err := config.Validate()
+			if c.problems == nil {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			validationErr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if strings.Join(validationErr.Problems, "\n") != strings.Join(c.problems, "\n") {
+				t.Errorf("problems:\n%s\nexpected:\n%s", strings.Join(validationErr.Problems, "\n"), strings.Join(c.problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	data := "root: /storage\nemail-to: admin@example.org\narchive-command: \"rm -rf ${path}\"\narchive-intervall: 60\n"
+	if err := ioutil.WriteFile(path, []byte(data), FileModeCreate); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	validationErr, ok := err.(*ValidationError)
+	if !ok || config == nil || len(validationErr.Problems) != 1 || !strings.Contains(validationErr.Problems[0], "archive-intervall") {
+		t.Errorf("the unknown key is not reported: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const DatasetFileName = ".datasetinfo"
+const FramesFolderName = "frames"
+
+var CharacterFolderNames = [...]string{"frames", "Images-Disc1"}
+
+type Datasetinfo struct {
+	ID         string       `yaml:"id"`
+	BackupTime sql.NullTime `yaml:"backup-time"`
+	// overrides of the policy, written by the owner of the dataset.
+	// They are checked against the limits of the root.
+	ArchiveInterval int      `yaml:"archive-interval,omitempty"` // within max-archive-interval of the root
+	NoticeTo        string   `yaml:"notice-to,omitempty"`        // comma separated, extra recipients of the notices
+	BackupTarget    string   `yaml:"backup-target,omitempty"`    // one of the backup-targets of the root
+	NoBackup        bool     `yaml:"no-backup,omitempty"`        // if allow-no-backup is set for the root
+	Hold            string   `yaml:"hold,omitempty"`             // reason to keep the dataset when capacity mode archives datasets early
+	Activity        []string `yaml:"activity,omitempty"`         // signals added to the activity signals of the root
+	// the id of the dataset this folder is copied from, set when the copy gets its own id
+	CopiedFrom string `yaml:"copied-from,omitempty"`
+}
+
+// read dataset info stored in the .datasetinfo file of a dataset folder
+// path: path to the dataset folder
+func ReadDatasetinfo(fsys FileSystem, path string) (*Datasetinfo, error) {
+	datasetfilePath := filepath.Join(path, DatasetFileName)
+	data, err := fsys.ReadFile(datasetfilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("can not open config file %s", path))
+	}
+	info := Datasetinfo{}
+	err = yaml.Unmarshal(data, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// If the path is a valid folder, check if it's a dataset folder by the following rules:
+//
+// 1. If a .datasetinfo file is under this folder
+//
+// 2. If a folder named "frames", or another character folder of the policy, is under this folder
+//
+// # If it's a dataset folder, but there's no .datasetinfo file inside it, create it and add the record to the database
+//
+// # If there's a .datasetinfo file inside, but the id is not recorded by the database, add it to the database
+//
+// # If the .datasetinfo file is removed from a known dataset, write it again with the id of its record
+//
+// return true if it's a dataset folder
+func (e *Engine) CreateIfDataset(policy *Policy, path string) (bool, error) {
+	isDataset, record, err := e.findDataset(policy, path)
+	if err != nil || record == nil {
+		return isDataset, err
+	}
+	return isDataset, e.Store.UpdateRecord(record)
+}
+
+// the same as CreateIfDataset, but the record to add or update is returned instead of written,
+// nil if the record is up to date. The .datasetinfo file is still written.
+func (e *Engine) findDataset(policy *Policy, path string) (bool, *DatasetRecord, error) {
+	datasetfilePath := filepath.Join(path, DatasetFileName)
+	_, err := e.FS.Stat(datasetfilePath)
+	if err != nil { // .datasetinfo folder doesn't exist
+		known, err := e.Store.GetRecordsByPath(path)
+		if err != nil {
+			return false, nil, err
+		}
+		if len(known) > 1 {
+			return true, nil, pathConflictError(path, recordIDs(known))
+		} else if len(known) == 1 {
+			// the file is removed, the dataset keeps its id
+			log.Printf("restore %s of dataset %s, %s", DatasetFileName, known[0].ID, path)
+			return true, nil, SaveDatasetInfo(e.FS, path, &Datasetinfo{ID: known[0].ID})
+		}
+		if e.containsCharacterFolder(path, policy.CharacterFolders) {
+			record, err := e.newDataset(path)
+			if err != nil {
+				return false, nil, err
+			}
+			return true, record, nil
+		}
+	} else { // if .datasetinfo folder already exists, then it's a dataset folder
+		data, err := e.FS.ReadFile(datasetfilePath)
+		if err != nil {
+			return false, nil, err
+		}
+		info := Datasetinfo{}
+		err = yaml.Unmarshal(data, &info)
+		if err != nil {
+			return false, nil, err
+		}
+		id := info.ID
+		record, err := e.Store.GetRecord(id)
+		if err != nil {
+			return false, nil, err
+		}
+		if record == nil {
+			record = &DatasetRecord{
+				ID:   id,
+				Path: path,
+			}
+			return true, record, nil
+		} else if record.Path != path {
+			if e.holdsID(record.Path, id) { // the folder is copied, not moved
+				record, err := e.copyDataset(path, &info)
+				if err != nil {
+					return true, nil, err
+				}
+				return true, record, nil
+			}
+			log.Printf("dataset %s is moved from %s to %s", id, record.Path, path)
+			record.moveTo(path, e.Clock.Now())
+			return true, record, nil
+		}
+		return true, nil, nil
+	}
+	return false, nil, nil
+}
+
+func recordIDs(records []DatasetRecord) []string {
+	ids := make([]string, 0, len(records))
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// several records claim the same path, e.g. after the .datasetinfo file is replaced.
+// The admin has to delete the wrong records.
+func pathConflictError(path string, ids []string) error {
+	return errors.Errorf("path %s is claimed by several datasets: %s", path, strings.Join(ids, ", "))
+}
+
+// if the .datasetinfo file of the folder has the id
+func (e *Engine) holdsID(path string, id string) bool {
+	info, err := ReadDatasetinfo(e.FS, path)
+	return err == nil && info.ID == id
+}
+
+// give a copied dataset folder its own id, the id it's copied from is kept as its lineage.
+// The copy isn't backed up yet.
+func (e *Engine) copyDataset(path string, info *Datasetinfo) (*DatasetRecord, error) {
+	copied := *info
+	copied.ID = uuid.New().String()
+	copied.CopiedFrom = info.ID
+	copied.BackupTime = sql.NullTime{}
+	if err := SaveDatasetInfo(e.FS, path, &copied); err != nil {
+		return nil, errors.Wrap(err, "can't give the copied dataset a new id")
+	}
+	log.Printf("dataset %s is a copy of %s, new id %s", path, info.ID, copied.ID)
+	return &DatasetRecord{ID: copied.ID, Path: path, CopiedFrom: info.ID}, nil
+}
+
+// contains character folder that can decide it's a dataset folder
+func (e *Engine) containsCharacterFolder(path string, characterFolders []string) bool {
+	for _, dir := range characterFolders {
+		dirPath := filepath.Join(path, dir)
+		d, err := e.FS.Stat(dirPath)
+		if err == nil && d.IsDir() { //frames folder exists
+			return true
+		}
+	}
+	return false
+}
+
+// Add a folder as a dataset folder, it will do the following things:
+//
+// 1. add a .datasetinfo file to the folder
+//
+// 2. add the record to the database
+func (e *Engine) AddDataset(path string) error {
+	record, err := e.newDataset(path)
+	if err != nil {
+		return err
+	}
+	return e.Store.AddRecord(record)
+}
+
+// add a .datasetinfo file to the folder, and return the record to add
+func (e *Engine) newDataset(path string) (*DatasetRecord, error) {
+	id, err := e.createDatasetInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DatasetRecord{ID: id, Path: path}, nil
+}
+
+// Create a .datasetinfo file to the folder
+// add id property inside it
+//
+// path is the path of folder to be marked as dataset
+//
+// return it's id
+func (e *Engine) createDatasetInfo(path string) (string, error) {
+	id := uuid.New().String()
+	info := Datasetinfo{ID: id}
+	err := SaveDatasetInfo(e.FS, path, &info)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func SaveDatasetInfo(fsys FileSystem, path string, info *Datasetinfo) error {
+	datasetfilePath := filepath.Join(path, DatasetFileName)
+	data, err := yaml.Marshal(info)
+	if err != nil {
+		return err
+	}
+	err = fsys.WriteFile(datasetfilePath, data, FileModeCreate)
+	return err
+}
@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCopiedDataset(t *testing.T) {
+	e, sim, clock := newSimulation(t, simTime(0, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := ReadDatasetinfo(e.FS, ds1)
+	if _, err := e.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	// cp -r ds1 ds2
+	ds2 := filepath.Join(filepath.Dir(ds1), "ds2")
+	data, _ := sim.fsys.ReadFile(filepath.Join(ds1, DatasetFileName))
+	sim.fsys.AddFile(filepath.Join(ds2, DatasetFileName), data, simTime(1, 0))
+	addFrames(t, sim.fsys, ds2, simTime(1, 0), "frames/1.tif")
+	clock.Set(simTime(1, 2))
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := e.Store.GetRecord(original.ID); record == nil || record.Path != ds1 {
+		t.Errorf("the record of the original follows the copy: %+v", record)
+	}
+	info, _ := ReadDatasetinfo(e.FS, ds2)
+	if info.ID == original.ID || info.CopiedFrom != original.ID || info.BackupTime.Valid {
+		t.Errorf("unexpected %s of the copy %+v", DatasetFileName, info)
+	}
+	result, err := e.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CopiedDataset{{Root: "/storage", ID: info.ID, Path: ds2, FromID: original.ID, FromPath: ds1}}
+	if !reflect.DeepEqual(result.Copies, expected) {
+		t.Errorf("unexpected copies %+v", result.Copies)
+	}
+	result, _ = e.Scan()
+	if len(result.Copies) != 0 {
+		t.Errorf("the copy is reported again %+v", result.Copies)
+	}
+
+	// a moved dataset keeps its id
+	ds3 := filepath.Join(filepath.Dir(ds1), "ds3")
+	data, _ = sim.fsys.ReadFile(filepath.Join(ds2, DatasetFileName))
+	sim.fsys.RemoveAll(ds2)
+	sim.fsys.AddFile(filepath.Join(ds3, DatasetFileName), data, simTime(1, 0))
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := e.Store.GetRecord(info.ID); record == nil || record.Path != ds3 {
+		t.Errorf("the record doesn't follow the moved dataset: %+v", record)
+	}
+}
+
+func TestRestoreDatasetinfo(t *testing.T) {
+	e, sim, _ := newSimulation(t, simTime(0, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := e.Store.ListActiveRecords()
+	if err := sim.fsys.RemoveAll(filepath.Join(ds1, DatasetFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadDatasetinfo(e.FS, ds1)
+	if err != nil || info.ID != records[0].ID {
+		t.Errorf("the %s file is not restored with id %s: %+v, %v", DatasetFileName, records[0].ID, info, err)
+	}
+
+	// a second record of the path is reported
+	e.Store.AddRecord(&DatasetRecord{ID: "other", Path: ds1})
+	result, err := e.ScanRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Path != ds1 || !strings.Contains(result.Errors[0].Msg, "claimed by several datasets") {
+		t.Errorf("the conflict is not reported: %+v", result.Errors)
+	}
+	found, _ := e.FindRecords(ds1 + "/")
+	if len(found) != 2 {
+		t.Errorf("%d records found by path, expected 2", len(found))
+	}
+}
@@ -0,0 +1,467 @@
+package archive
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const Bucket_Active = "active"
+const Bucket_Archived = "archived"
+
+// records of the datasets which are gone without being archived
+const Bucket_Vanished = "vanished"
+
+// index of the active records by path, the keys are the path and the id separated by a zero byte.
+// Two ids under the same path are a conflict, e.g. when a .datasetinfo is replaced.
+const Bucket_Paths = "paths"
+
+// values of the runs which are not records, e.g. the month of the last usage report
+const Bucket_State = "state"
+
+type DatasetRecord struct {
+	ID              string
+	Path            string
+	LastModifyTime  sql.NullTime // the latest modify time of any file in the folder
+	ScanTime        sql.NullTime // when it's last scanned
+	NoticedLeftDays int          // Reminder for archiving in NoticedLeftDays have been sent
+	ArchiveTime     sql.NullTime // When record is archived
+	Stats           DatasetStats // counted by the last scan
+	Owner           *Owner       // owner of the folder, nil if it's not known
+	Unreadable      int          // entries the last scan couldn't read
+	ActivitySignal  string       // the signal of LastModifyTime, see ActivitySignals
+	Dirty           bool         // changed since the last backup, set by the Watcher
+	CopiedFrom      string       // id of the dataset the folder is copied from
+	PathHistory     []PathChange // the previous paths, the oldest first
+	MissingSince    sql.NullTime // when the path is found gone, cleared when the dataset is found again
+	VanishTime      sql.NullTime // when the missing dataset is given up and moved to the vanished records
+	BackupTime      sql.NullTime // the files modified until then are backed up, the same as in .datasetinfo
+	// the day the dataset is archived to free space in capacity mode,
+	// cleared when the dataset is modified
+	CapacityDeadline sql.NullTime
+}
+
+// PathChange is a previous path of a moved dataset
+type PathChange struct {
+	Path  string
+	Until time.Time // when the dataset is found at its next path
+}
+
+// record the move of the dataset to path
+func (r *DatasetRecord) moveTo(path string, now time.Time) {
+	r.PathHistory = append(r.PathHistory, PathChange{Path: r.Path, Until: now})
+	r.Path = path
+	r.MissingSince = sql.NullTime{}
+}
+
+// return the last move if it's found after the last scan, else nil
+func (r *DatasetRecord) movedSinceScan() *PathChange {
+	if len(r.PathHistory) == 0 {
+		return nil
+	}
+	last := r.PathHistory[len(r.PathHistory)-1]
+	if r.ScanTime.Valid && !last.Until.After(r.ScanTime.Time) {
+		return nil
+	}
+	return &last
+}
+
+// Owner is the user and the group owning a dataset folder
+type Owner struct {
+	UID int
+	GID int
+}
+
+// Store keeps the dataset records
+type Store interface {
+	AddRecord(record *DatasetRecord) error
+	UpdateRecord(record *DatasetRecord) error
+	// write the changes of the active records in one transaction
+	WriteBatch(batch *RecordBatch) error
+	// return nil if the record doesn't exist
+	GetRecord(id string) (*DatasetRecord, error)
+	// return the active records of a path, more than one if several ids claim it
+	GetRecordsByPath(path string) ([]DatasetRecord, error)
+	// return the paths claimed by several active records, with their ids
+	PathConflicts() (map[string][]string, error)
+	DeleteRecord(id string) error
+	// move the record from the active records to the archived records
+	SaveArchiveRecord(record *DatasetRecord) error
+	ListActiveRecords() ([]DatasetRecord, error)
+	ListArchivedRecords() ([]DatasetRecord, error)
+	ListVanishedRecords() ([]DatasetRecord, error)
+	// call fn with the records of a bucket one by one, in the order of their ids
+	EachRecord(bucket string, fn func(record *DatasetRecord) error) error
+	// put the records into a bucket as they are, without changing the other buckets, e.g. by an import
+	PutRecords(bucket string, records []*DatasetRecord) error
+	// return the state value of the key, empty if it's not set
+	GetState(key string) (string, error)
+	SetState(key string, value string) error
+	Close() error
+}
+
+// RecordBatch is a group of changes of the active records
+type RecordBatch struct {
+	Updates []*DatasetRecord // added or updated
+	Deletes []string         // ids of the deleted records
+	// moved from the active records to the archived or the vanished records
+	Archived []*DatasetRecord
+	Vanished []*DatasetRecord
+}
+
+func (b *RecordBatch) Len() int {
+	return len(b.Updates) + len(b.Deletes) + len(b.Archived) + len(b.Vanished)
+}
+
+// the buckets of the records, the path index is built from the active records
+var RecordBuckets = []string{Bucket_Active, Bucket_Archived, Bucket_Vanished}
+
+// BoltStore is the Store saved in a bolt database file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err = tx.CreateBucketIfNotExists([]byte(Bucket_Active))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(Bucket_Archived))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(Bucket_Vanished))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(Bucket_State))
+		if err != nil {
+			return err
+		}
+		if tx.Bucket([]byte(Bucket_Paths)) == nil { // a database of an older version
+			return buildPathIndex(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// create the path index from the active records
+func buildPathIndex(tx *bolt.Tx) error {
+	paths, err := tx.CreateBucket([]byte(Bucket_Paths))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(Bucket_Active)).ForEach(func(k, v []byte) error {
+		record, err := decodeRecord(v)
+		if err != nil {
+			return err
+		}
+		return paths.Put(pathKey(record.Path, record.ID), []byte{})
+	})
+}
+
+func pathKey(path string, id string) []byte {
+	return []byte(path + "\x00" + id)
+}
+
+// put an active record, a vanished dataset found again is active again
+func putRecord(tx *bolt.Tx, record *DatasetRecord) error {
+	if err := putActiveRecord(tx, record); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(Bucket_Vanished)).Delete([]byte(record.ID))
+}
+
+// put an active record and update the path index
+func putActiveRecord(tx *bolt.Tx, record *DatasetRecord) error {
+	bucket := tx.Bucket([]byte(Bucket_Active))
+	paths := tx.Bucket([]byte(Bucket_Paths))
+	if old := bucket.Get([]byte(record.ID)); old != nil {
+		oldRecord, err := decodeRecord(old)
+		if err != nil {
+			return err
+		}
+		if oldRecord.Path != record.Path {
+			if err := paths.Delete(pathKey(oldRecord.Path, record.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	data, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte(record.ID), data); err != nil {
+		return err
+	}
+	return paths.Put(pathKey(record.Path, record.ID), []byte{})
+}
+
+// delete an active record and its path from the index
+func deleteRecord(tx *bolt.Tx, id string) error {
+	bucket := tx.Bucket([]byte(Bucket_Active))
+	old := bucket.Get([]byte(id))
+	if old == nil {
+		return nil
+	}
+	oldRecord, err := decodeRecord(old)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte(Bucket_Paths)).Delete(pathKey(oldRecord.Path, id)); err != nil {
+		return err
+	}
+	return bucket.Delete([]byte(id))
+}
+
+// move an active record to another bucket
+func moveRecord(tx *bolt.Tx, record *DatasetRecord, bucket string) error {
+	if err := deleteRecord(tx, record.ID); err != nil {
+		return err
+	}
+	data, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(bucket)).Put([]byte(record.ID), data)
+}
+
+func (s *BoltStore) AddRecord(record *DatasetRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx, record)
+	})
+}
+
+func (s *BoltStore) UpdateRecord(record *DatasetRecord) error {
+	return s.AddRecord(record)
+}
+
+func (s *BoltStore) WriteBatch(batch *RecordBatch) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, record := range batch.Updates {
+			if err := putRecord(tx, record); err != nil {
+				return err
+			}
+		}
+		for _, id := range batch.Deletes {
+			if err := deleteRecord(tx, id); err != nil {
+				return err
+			}
+		}
+		for _, record := range batch.Archived {
+			if err := moveRecord(tx, record, Bucket_Archived); err != nil {
+				return err
+			}
+		}
+		for _, record := range batch.Vanished {
+			if err := moveRecord(tx, record, Bucket_Vanished); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *BoltStore) PutRecords(bucketName string, records []*DatasetRecord) error {
+	if !containsString(RecordBuckets, bucketName) {
+		return errors.Errorf("%s is not a bucket of records", bucketName)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, record := range records {
+			if bucketName == Bucket_Active {
+				if err := putActiveRecord(tx, record); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := encodeRecord(record)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(bucketName)).Put([]byte(record.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetRecord(id string) (*DatasetRecord, error) {
+	var record *DatasetRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(Bucket_Active))
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		recordEntity, err := decodeRecord(data)
+		if err != nil {
+			return err
+		}
+		record = recordEntity
+		return nil
+	})
+	return record, err
+}
+
+func (s *BoltStore) GetRecordsByPath(path string) ([]DatasetRecord, error) {
+	list := make([]DatasetRecord, 0, 1)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		active := tx.Bucket([]byte(Bucket_Active))
+		prefix := pathKey(path, "")
+		c := tx.Bucket([]byte(Bucket_Paths)).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			data := active.Get(k[len(prefix):])
+			if data == nil {
+				continue
+			}
+			record, err := decodeRecord(data)
+			if err != nil {
+				return err
+			}
+			list = append(list, *record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *BoltStore) PathConflicts() (map[string][]string, error) {
+	conflicts := make(map[string][]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// the keys are sorted, the ids of a path are next to each other
+		var lastPath string
+		var lastID string
+		return tx.Bucket([]byte(Bucket_Paths)).ForEach(func(k, v []byte) error {
+			i := bytes.IndexByte(k, 0)
+			path, id := string(k[:i]), string(k[i+1:])
+			if path == lastPath {
+				if len(conflicts[path]) == 0 {
+					conflicts[path] = append(conflicts[path], lastID)
+				}
+				conflicts[path] = append(conflicts[path], id)
+			}
+			lastPath, lastID = path, id
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+func encodeRecord(record *DatasetRecord) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(record)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(data []byte) (*DatasetRecord, error) {
+	d := DatasetRecord{}
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *BoltStore) DeleteRecord(id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx, id)
+	})
+	return err
+}
+
+func (s *BoltStore) SaveArchiveRecord(record *DatasetRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return moveRecord(tx, record, Bucket_Archived)
+	})
+}
+
+func (s *BoltStore) ListActiveRecords() ([]DatasetRecord, error) {
+	return s.listBucketRecords(Bucket_Active)
+}
+
+func (s *BoltStore) ListArchivedRecords() ([]DatasetRecord, error) {
+	return s.listBucketRecords(Bucket_Archived)
+}
+
+func (s *BoltStore) ListVanishedRecords() ([]DatasetRecord, error) {
+	return s.listBucketRecords(Bucket_Vanished)
+}
+
+func (s *BoltStore) listBucketRecords(bucketName string) ([]DatasetRecord, error) {
+	list := make([]DatasetRecord, 0, 10)
+	err := s.EachRecord(bucketName, func(record *DatasetRecord) error {
+		list = append(list, *record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *BoltStore) EachRecord(bucketName string, fn func(record *DatasetRecord) error) error {
+	if !containsString(RecordBuckets, bucketName) {
+		return errors.Errorf("%s is not a bucket of records", bucketName)
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil { // a database of an older version opened read-only
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			record, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			return fn(record)
+		})
+	})
+}
+
+func (s *BoltStore) GetState(key string) (string, error) {
+	var value string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(Bucket_State)); bucket != nil {
+			value = string(bucket.Get([]byte(key)))
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStore) SetState(key string, value string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(Bucket_State)).Put([]byte(key), []byte(value))
+	})
+}
@@ -0,0 +1,254 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// size of the transactions copying the database by CompactBoltFile
+const compactTxSize = 64 * 1024 * 1024
+
+// the time in the names of the snapshots
+const snapshotTimeFormat = "20060102-150405"
+
+// Backup writes a consistent copy of the database to dest, while the store is used.
+// An existing dest is replaced.
+func (s *BoltStore) Backup(dest string) error {
+	return writeBackup(s.db, dest)
+}
+
+// BackupBoltFile writes a consistent copy of the database file at path to dest.
+// A run holding the database locks the file, it waits up to timeout for the run to finish.
+func BackupBoltFile(path string, dest string, timeout time.Duration) error {
+	db, err := openBoltReadOnly(path, timeout)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return writeBackup(db, dest)
+}
+
+// OpenBoltStoreReadOnly opens an existing bolt database to read its records, e.g. to migrate it.
+// A run holding the database locks the file, it waits up to timeout for the run to finish.
+func OpenBoltStoreReadOnly(path string, timeout time.Duration) (*BoltStore, error) {
+	db, err := openBoltReadOnly(path, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func openBoltReadOnly(path string, timeout time.Duration) (*bolt.DB, error) {
+	if _, err := os.Stat(path); err != nil { // bolt would create it
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, errors.Errorf("the database %s is used by another process", path)
+	}
+	return db, err
+}
+
+// the copy is written to a temporary file first, dest is never a partial copy
+func writeBackup(db *bolt.DB, dest string) error {
+	tmp := dest + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "failed to copy the database to %s", dest)
+	}
+	return nil
+}
+
+// CompactBoltFile rewrites the database file at path without its free pages, and returns the sizes
+// before and after. No run may use the database, it waits up to timeout for the file lock.
+func CompactBoltFile(path string, timeout time.Duration) (int64, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return 0, 0, errors.Errorf("the database %s is used by another process", path)
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+	tmp := path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, info.Mode().Perm(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = bolt.Compact(dst, src, compactTxSize)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, 0, errors.Wrap(err, "failed to compact the database")
+	}
+	compacted, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), compacted.Size(), nil
+}
+
+// ErrNoBackupServer is returned by RequestBackup if no process serves the backups
+var ErrNoBackupServer = errors.New("no run or watch daemon uses the database")
+
+// ServeBackups copies the database for the requests of RequestBackup on the unix socket at path,
+// while the runs use it. bolt locks the database file of a run, no other process can read it.
+// Closing the server removes the socket.
+func (e *Engine) ServeBackups(path string) (io.Closer, error) {
+	store, ok := e.Store.(backupStore)
+	if !ok {
+		return nil, errors.New("the store can't be copied")
+	}
+	os.Remove(path) // left by a process which didn't stop cleanly
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen for backup requests")
+	}
+	// the copy is written as the user of the runs
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil { // closed
+				return
+			}
+			go serveBackup(store, conn)
+		}
+	}()
+	return listener, nil
+}
+
+// a request is the absolute path of the copy on a line, the answer is ok or the error on a line
+func serveBackup(store backupStore, conn net.Conn) {
+	defer conn.Close()
+	dest, err := bufio.NewReader(conn).ReadString('\n')
+	if err == nil {
+		dest = strings.TrimSuffix(dest, "\n")
+		if filepath.IsAbs(dest) {
+			err = store.Backup(dest)
+		} else {
+			err = errors.Errorf("%s is not an absolute path", dest)
+		}
+	}
+	answer := "ok"
+	if err != nil {
+		answer = strings.ReplaceAll(err.Error(), "\n", " ")
+	}
+	fmt.Fprintln(conn, answer)
+}
+
+// RequestBackup asks the process serving the backups on the unix socket to copy the database to dest.
+// It returns ErrNoBackupServer if no process listens on the socket.
+func RequestBackup(socket string, dest string) error {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return ErrNoBackupServer
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, dest); err != nil {
+		return errors.Wrap(err, "failed to request the backup")
+	}
+	answer, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return errors.Wrap(err, "no answer to the backup request")
+	}
+	if answer = strings.TrimSuffix(answer, "\n"); answer != "ok" {
+		return errors.New(answer)
+	}
+	return nil
+}
+
+// a Store which can copy itself while it's used
+type backupStore interface {
+	Backup(dest string) error
+}
+
+// Snapshot copies the database into the snapshot folder before a run, so a bad run can be rolled back,
+// and removes the oldest snapshots over db-snapshots. It returns the path of the snapshot,
+// empty if db-snapshots is 0.
+func (e *Engine) Snapshot() (string, error) {
+	if e.Config.DBSnapshots == 0 {
+		return "", nil
+	}
+	store, ok := e.Store.(backupStore)
+	if !ok {
+		return "", errors.New("the store can't be copied")
+	}
+	folder := e.Config.SnapshotFolder()
+	if err := os.MkdirAll(folder, FolderModeCreate); err != nil {
+		return "", errors.Wrap(err, "failed to create the snapshot folder")
+	}
+	ext := filepath.Ext(e.Config.DB)
+	prefix := strings.TrimSuffix(filepath.Base(e.Config.DB), ext) + "-"
+	dest := filepath.Join(folder, prefix+e.Clock.Now().Format(snapshotTimeFormat)+ext)
+	if err := store.Backup(dest); err != nil {
+		return "", err
+	}
+
+	// the names sort by time, the other files in the folder are kept
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return dest, errors.Wrap(err, "failed to list the snapshots")
+	}
+	snapshots := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(snapshotTimeFormat, stamp); err == nil {
+			snapshots = append(snapshots, name)
+		}
+	}
+	sort.Strings(snapshots)
+	for i := 0; i < len(snapshots)-e.Config.DBSnapshots; i++ {
+		if err := os.Remove(filepath.Join(folder, snapshots[i])); err != nil {
+			return dest, errors.Wrap(err, "failed to remove an old snapshot")
+		}
+	}
+	return dest, nil
+}
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDBBackup(t *testing.T) {
+	dir := t.TempDir()
+	e, _, clock := newSimulation(t, simTime(0, 2))
+	e.Config.DB = filepath.Join(dir, "archive.db")
+	e.Config.DBSnapshots = 2
+	store, err := OpenBoltStore(e.Config.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	e.Store = store
+	batch := &RecordBatch{}
+	for i := 0; i < 2000; i++ {
+		batch.Updates = append(batch.Updates, &DatasetRecord{ID: fmt.Sprintf("id%d", i), Path: fmt.Sprintf("/storage/ds%d", i)})
+	}
+	if err := store.WriteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy while the store is open
+	copyPath := filepath.Join(dir, "copy.db")
+	if err := store.Backup(copyPath); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := OpenBoltStore(copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, _ := copied.ListActiveRecords()
+	copied.Close()
+	if len(records) != 2000 {
+		t.Errorf("%d records in the copy, expected 2000", len(records))
+	}
+	if err := BackupBoltFile(e.Config.DB, copyPath, 10*time.Millisecond); err == nil {
+		t.Errorf("the database used by the store is copied")
+	}
+	// the process using the database copies it
+	server, err := e.ServeBackups(e.Config.BackupSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(copyPath)
+	if err := RequestBackup(e.Config.BackupSocket(), copyPath); err != nil {
+		t.Error(err)
+	} else if _, err := os.Stat(copyPath); err != nil {
+		t.Error(err)
+	}
+	if err := RequestBackup(e.Config.BackupSocket(), filepath.Join(dir, "missing", "copy.db")); err == nil || err == ErrNoBackupServer {
+		t.Errorf("the error of the copy is not returned: %v", err)
+	}
+	server.Close()
+	if err := RequestBackup(e.Config.BackupSocket(), copyPath); err != ErrNoBackupServer {
+		t.Errorf("backup requested without a server: %v", err)
+	}
+
+	// only the newest snapshots are kept, the other files in the folder are left alone
+	if err := os.MkdirAll(e.Config.SnapshotFolder(), 0750); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"archive-before-upgrade.db", "archive-0.db"} {
+		if err := ioutil.WriteFile(filepath.Join(e.Config.SnapshotFolder(), name), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for day := 1; day <= 3; day++ {
+		clock.Set(simTime(day, 2))
+		if _, err := e.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := os.ReadDir(e.Config.SnapshotFolder())
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{"archive-0.db", "archive-" + simTime(2, 2).Format("20060102-150405") + ".db",
+		"archive-" + simTime(3, 2).Format("20060102-150405") + ".db", "archive-before-upgrade.db"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("snapshots %v, expected %v", names, expected)
+	}
+
+	// the free pages of the deleted records are dropped
+	deletes := make([]string, 0, 2000)
+	for _, r := range records {
+		deletes = append(deletes, r.ID)
+	}
+	if err := store.WriteBatch(&RecordBatch{Deletes: deletes}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := CompactBoltFile(e.Config.DB, 10*time.Millisecond); err == nil {
+		t.Errorf("the database used by the store is compacted")
+	}
+	store.Close()
+	before, after, err := CompactBoltFile(e.Config.DB, time.Second)
+	if err != nil || after >= before {
+		t.Errorf("compacted from %d to %d bytes, error %v", before, after, err)
+	}
+	if err := BackupBoltFile(e.Config.DB, copyPath, time.Second); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,399 @@
+package archive
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // pure go, no cgo
+)
+
+// the backends of the Store, selected by db-backend
+const (
+	BackendBolt   = "bolt"
+	BackendSQLite = "sqlite"
+)
+
+var StoreBackends = []string{BackendBolt, BackendSQLite}
+
+// OpenStore opens the database of the config with its backend
+func OpenStore(config *AppConfig) (Store, error) {
+	// no typed nil in the Store when it fails
+	if config.DBBackend == BackendSQLite {
+		store, err := OpenSQLiteStore(config.DB)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	store, err := OpenBoltStore(config.DB)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// the columns of the records table are the ones of the csv export,
+// these are integers, 0 and 1 for dirty. The other columns are text, the times in RFC 3339.
+var sqliteIntegerColumns = map[string]bool{
+	"noticed_left_days": true, "dirty": true, "unreadable": true, "owner_uid": true, "owner_gid": true,
+	"bytes": true, "allocated": true, "files": true, "dirs": true,
+}
+
+// SQLiteStore is the Store saved in a SQLite database file, in the tables records and state.
+// The bucket column holds the bucket of BoltStore, the active records by path are found by an index.
+type SQLiteStore struct {
+	db      *sql.DB
+	columns string // the columns in the order of exportColumns
+}
+
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	// a ?, # or % in the path is not a part of the uri
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?" +
+		url.Values{"_pragma": {"busy_timeout(10000)", "journal_mode(WAL)"}}.Encode()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defs := make([]string, 0, len(exportColumns)+1)
+	for _, column := range exportColumns {
+		if sqliteIntegerColumns[column] {
+			defs = append(defs, column+" INTEGER")
+		} else {
+			defs = append(defs, column+" TEXT")
+		}
+	}
+	defs = append(defs, "PRIMARY KEY (bucket, id)")
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS records (" + strings.Join(defs, ", ") + ")",
+		"CREATE INDEX IF NOT EXISTS records_path ON records (bucket, path)",
+		"CREATE TABLE IF NOT EXISTS state (key TEXT PRIMARY KEY, value TEXT)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "failed to create the records table")
+		}
+	}
+	return &SQLiteStore{db: db, columns: strings.Join(exportColumns, ", ")}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Backup writes a consistent copy of the database to dest, while the store is used.
+// An existing dest is replaced, like by BoltStore.Backup.
+func (s *SQLiteStore) Backup(dest string) error {
+	// VACUUM INTO doesn't write to an existing file
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	_, err := s.db.Exec("VACUUM INTO ?", tmp)
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "failed to copy the database to %s", dest)
+	}
+	return nil
+}
+
+// Compact rewrites the database without its free pages
+func (s *SQLiteStore) Compact() error {
+	_, err := s.db.Exec("VACUUM")
+	return errors.Wrap(err, "failed to compact the database")
+}
+
+// the values of the columns, NULL for the empty values
+func sqliteValues(bucket string, record *DatasetRecord) ([]interface{}, error) {
+	row, err := recordRow(bucket, record)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		switch {
+		case v == "":
+			values[i] = nil
+		case exportColumns[i] == "dirty":
+			values[i] = v == "true"
+		case sqliteIntegerColumns[exportColumns[i]]:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = n
+		default:
+			values[i] = v
+		}
+	}
+	return values, nil
+}
+
+func scanSQLiteRecord(rows *sql.Rows) (*DatasetRecord, error) {
+	cells := make([]sql.NullString, len(exportColumns))
+	dest := make([]interface{}, len(cells))
+	for i := range cells {
+		dest[i] = &cells[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	row := make([]string, len(cells))
+	for i, cell := range cells {
+		row[i] = cell.String
+	}
+	for i, column := range exportColumns {
+		if column == "dirty" {
+			row[i] = strconv.FormatBool(row[i] == "1")
+		}
+	}
+	_, record, err := rowRecord(row)
+	return record, err
+}
+
+// the records of a query on the columns, in the order of the query
+func (s *SQLiteStore) query(where string, args ...interface{}) ([]DatasetRecord, error) {
+	list := make([]DatasetRecord, 0, 1)
+	err := s.each(where, args, func(record *DatasetRecord) error {
+		list = append(list, *record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *SQLiteStore) each(where string, args []interface{}, fn func(record *DatasetRecord) error) error {
+	rows, err := s.db.Query("SELECT "+s.columns+" FROM records WHERE "+where, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		record, err := scanSQLiteRecord(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// put a record into a bucket, replacing the record of the id in the bucket
+func (s *SQLiteStore) putRecord(tx *sql.Tx, bucket string, record *DatasetRecord) error {
+	values, err := sqliteValues(bucket, record)
+	if err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	_, err = tx.Exec("INSERT OR REPLACE INTO records ("+s.columns+") VALUES ("+placeholders+")", values...)
+	return err
+}
+
+// put an active record, a vanished dataset found again is active again
+func (s *SQLiteStore) putActive(tx *sql.Tx, record *DatasetRecord) error {
+	if err := s.putRecord(tx, Bucket_Active, record); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM records WHERE bucket = ? AND id = ?", Bucket_Vanished, record.ID)
+	return err
+}
+
+func deleteSQLiteRecord(tx *sql.Tx, id string) error {
+	_, err := tx.Exec("DELETE FROM records WHERE bucket = ? AND id = ?", Bucket_Active, id)
+	return err
+}
+
+// move an active record to another bucket
+func (s *SQLiteStore) moveRecord(tx *sql.Tx, record *DatasetRecord, bucket string) error {
+	if err := deleteSQLiteRecord(tx, record.ID); err != nil {
+		return err
+	}
+	return s.putRecord(tx, bucket, record)
+}
+
+func (s *SQLiteStore) update(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) AddRecord(record *DatasetRecord) error {
+	return s.update(func(tx *sql.Tx) error {
+		return s.putActive(tx, record)
+	})
+}
+
+func (s *SQLiteStore) UpdateRecord(record *DatasetRecord) error {
+	return s.AddRecord(record)
+}
+
+func (s *SQLiteStore) WriteBatch(batch *RecordBatch) error {
+	return s.update(func(tx *sql.Tx) error {
+		for _, record := range batch.Updates {
+			if err := s.putActive(tx, record); err != nil {
+				return err
+			}
+		}
+		for _, id := range batch.Deletes {
+			if err := deleteSQLiteRecord(tx, id); err != nil {
+				return err
+			}
+		}
+		for _, record := range batch.Archived {
+			if err := s.moveRecord(tx, record, Bucket_Archived); err != nil {
+				return err
+			}
+		}
+		for _, record := range batch.Vanished {
+			if err := s.moveRecord(tx, record, Bucket_Vanished); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) PutRecords(bucket string, records []*DatasetRecord) error {
+	if !containsString(RecordBuckets, bucket) {
+		return errors.Errorf("%s is not a bucket of records", bucket)
+	}
+	return s.update(func(tx *sql.Tx) error {
+		for _, record := range records {
+			if err := s.putRecord(tx, bucket, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) GetRecord(id string) (*DatasetRecord, error) {
+	list, err := s.query("bucket = ? AND id = ?", Bucket_Active, id)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+func (s *SQLiteStore) GetRecordsByPath(path string) ([]DatasetRecord, error) {
+	return s.query("bucket = ? AND path = ? ORDER BY id", Bucket_Active, path)
+}
+
+func (s *SQLiteStore) PathConflicts() (map[string][]string, error) {
+	rows, err := s.db.Query(`SELECT path, id FROM records WHERE bucket = ? AND path IN
+		(SELECT path FROM records WHERE bucket = ? GROUP BY path HAVING count(*) > 1) ORDER BY path, id`,
+		Bucket_Active, Bucket_Active)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	conflicts := make(map[string][]string)
+	for rows.Next() {
+		var path, id string
+		if err := rows.Scan(&path, &id); err != nil {
+			return nil, err
+		}
+		conflicts[path] = append(conflicts[path], id)
+	}
+	return conflicts, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteRecord(id string) error {
+	return s.update(func(tx *sql.Tx) error {
+		return deleteSQLiteRecord(tx, id)
+	})
+}
+
+func (s *SQLiteStore) SaveArchiveRecord(record *DatasetRecord) error {
+	return s.update(func(tx *sql.Tx) error {
+		return s.moveRecord(tx, record, Bucket_Archived)
+	})
+}
+
+func (s *SQLiteStore) ListActiveRecords() ([]DatasetRecord, error) {
+	return s.query("bucket = ? ORDER BY id", Bucket_Active)
+}
+
+func (s *SQLiteStore) ListArchivedRecords() ([]DatasetRecord, error) {
+	return s.query("bucket = ? ORDER BY id", Bucket_Archived)
+}
+
+func (s *SQLiteStore) ListVanishedRecords() ([]DatasetRecord, error) {
+	return s.query("bucket = ? ORDER BY id", Bucket_Vanished)
+}
+
+func (s *SQLiteStore) EachRecord(bucket string, fn func(record *DatasetRecord) error) error {
+	if !containsString(RecordBuckets, bucket) {
+		return errors.Errorf("%s is not a bucket of records", bucket)
+	}
+	return s.each("bucket = ? ORDER BY id", []interface{}{bucket}, fn)
+}
+
+func (s *SQLiteStore) GetState(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM state WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *SQLiteStore) SetState(key string, value string) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO state (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+// CopyRecords copies the records of all the buckets of src into the empty store dst,
+// e.g. to move from a bolt database to a SQLite database. It returns the number of records copied.
+// Every record goes into its own bucket as it is, an id can be active and archived.
+// The records are saved in batches, dst must be deleted to copy again after an error.
+func CopyRecords(src Store, dst Store) (int, error) {
+	if empty, err := storeEmpty(dst); err != nil {
+		return 0, err
+	} else if !empty {
+		return 0, errors.New("the database is not empty, records can only be copied into a new database")
+	}
+	count := 0
+	for _, bucket := range RecordBuckets {
+		records := make([]*DatasetRecord, 0, importBatchSize)
+		write := func() error {
+			if err := dst.PutRecords(bucket, records); err != nil {
+				return errors.Wrapf(err, "failed to save %d records", len(records))
+			}
+			count += len(records)
+			records = records[:0]
+			return nil
+		}
+		err := src.EachRecord(bucket, func(record *DatasetRecord) error {
+			records = append(records, record)
+			if len(records) >= importBatchSize {
+				return write()
+			}
+			return nil
+		})
+		if err == nil {
+			err = write()
+		}
+		if err != nil {
+			if count > 0 {
+				err = errors.Wrapf(err, "%d records are saved, delete the database before copying again", count)
+			}
+			return count, errors.Wrapf(err, "failed to copy the %s records", bucket)
+		}
+	}
+	return count, nil
+}
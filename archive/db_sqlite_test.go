@@ -0,0 +1,146 @@
+package archive
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	dir := t.TempDir()
+	boltStore, err := OpenBoltStore(filepath.Join(dir, "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+	day := func(d int) sql.NullTime { return sql.NullTime{Time: simTime(d, 2), Valid: true} }
+	active := &DatasetRecord{
+		ID: "a", Path: "/storage/a", LastModifyTime: day(1), ScanTime: day(2), NoticedLeftDays: 5,
+		BackupTime: day(1), Dirty: true, ActivitySignal: SignalCtime, Owner: &Owner{UID: 1000, GID: 100},
+		Stats: DatasetStats{Time: simTime(2, 2), Bytes: 3000, Allocated: 4096, Files: 2, Dirs: 1,
+			LargestFiles: []FileSize{{Path: "frames/1.tif", Size: 2000}}, Nested: []string{"run1"}},
+		CopiedFrom: "b", PathHistory: []PathChange{{Path: "/storage/old", Until: simTime(1, 2)}},
+	}
+	conflict := &DatasetRecord{ID: "d", Path: "/storage/a"}
+	archived := &DatasetRecord{ID: "b", Path: "/storage/b", LastModifyTime: day(0), ArchiveTime: day(30)}
+	vanished := &DatasetRecord{ID: "c", Path: "/storage/c", MissingSince: day(3), VanishTime: day(10)}
+	err = boltStore.WriteBatch(&RecordBatch{Updates: []*DatasetRecord{active, conflict}, Archived: []*DatasetRecord{archived}, Vanished: []*DatasetRecord{vanished}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an archived dataset restored from the backup
+	if err := boltStore.PutRecords(Bucket_Active, []*DatasetRecord{{ID: "b", Path: "/storage/b", LastModifyTime: day(40)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the migration keeps the records
+	config := DefaultConfig()
+	config.DBBackend = BackendSQLite
+	config.DB = filepath.Join(dir, "archive.sqlite")
+	store, err := OpenStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if count, err := CopyRecords(boltStore, store); err != nil || count != 5 {
+		t.Fatalf("%d records copied, err: %v", count, err)
+	}
+	list := func(s Store) [][]DatasetRecord {
+		a, _ := s.ListActiveRecords()
+		b, _ := s.ListArchivedRecords()
+		c, _ := s.ListVanishedRecords()
+		return [][]DatasetRecord{a, b, c}
+	}
+	if r, _ := store.GetRecord("b"); r == nil || !r.LastModifyTime.Time.Equal(simTime(40, 2)) {
+		t.Errorf("the active record of an archived id is not migrated: %+v", r)
+	}
+	if !reflect.DeepEqual(list(store), list(boltStore)) {
+		t.Errorf("migrated records:\n%+v\nexpected:\n%+v", list(store), list(boltStore))
+	}
+	if _, err := CopyRecords(boltStore, store); err == nil {
+		t.Errorf("records copied into a database which is not empty")
+	}
+
+	// the same answers as the boltStore store
+	for _, s := range []Store{boltStore, store} {
+		if found, _ := s.GetRecordsByPath("/storage/a"); len(found) != 2 || found[0].ID != "a" || found[1].ID != "d" {
+			t.Errorf("%T: records of the path %+v", s, found)
+		}
+		if conflicts, _ := s.PathConflicts(); !reflect.DeepEqual(conflicts, map[string][]string{"/storage/a": {"a", "d"}}) {
+			t.Errorf("%T: conflicts %v", s, conflicts)
+		}
+		// a vanished dataset found again
+		if err := s.WriteBatch(&RecordBatch{Updates: []*DatasetRecord{{ID: "c", Path: "/storage/c2"}}, Deletes: []string{"d"}}); err != nil {
+			t.Fatal(err)
+		}
+		if r, _ := s.GetRecord("c"); r == nil || r.Path != "/storage/c2" {
+			t.Errorf("%T: record of the dataset found again %+v", s, r)
+		}
+		if r, _ := s.GetRecord("d"); r != nil {
+			t.Errorf("%T: deleted record %+v", s, r)
+		}
+		if c, _ := s.ListVanishedRecords(); len(c) != 0 {
+			t.Errorf("%T: the dataset found again is still vanished", s)
+		}
+		if err := s.SaveArchiveRecord(active); err != nil {
+			t.Fatal(err)
+		}
+		if r, _ := s.GetRecord("a"); r != nil {
+			t.Errorf("%T: archived record is active", s)
+		}
+		if err := s.EachRecord(Bucket_Paths, func(record *DatasetRecord) error { return nil }); err == nil {
+			t.Errorf("%T: the path index is listed as records", s)
+		}
+		if value, err := s.GetState("usage-report-month"); err != nil || value != "" {
+			t.Errorf("%T: state %q of a new database, error %v", s, value, err)
+		}
+		if err := s.SetState("usage-report-month", "2026-10"); err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := s.GetState("usage-report-month"); value != "2026-10" {
+			t.Errorf("%T: state %q", s, value)
+		}
+	}
+	if !reflect.DeepEqual(list(store), list(boltStore)) {
+		t.Errorf("sqlite records:\n%+v\nbolt records:\n%+v", list(store), list(boltStore))
+	}
+
+	// a copy while the store is open
+	copyPath := filepath.Join(dir, "copy.sqlite")
+	if err := ioutil.WriteFile(copyPath, []byte("an older copy"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.(*SQLiteStore).Backup(copyPath); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := OpenSQLiteStore(copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	if !reflect.DeepEqual(list(copied), list(store)) {
+		t.Errorf("copied records:\n%+v\nexpected:\n%+v", list(copied), list(store))
+	}
+}
+
+func TestSQLiteStorePath(t *testing.T) {
+	// the characters of an uri in the path
+	path := filepath.Join(t.TempDir(), "a?b#c%20d", "archive.sqlite")
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.AddRecord(&DatasetRecord{ID: "a", Path: "/storage/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the database is not at its path: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPathIndex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "archive.db")
+	store, err := OpenBoltStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(path string) []string {
+		records, err := store.GetRecordsByPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recordIDs(records)
+	}
+	store.AddRecord(&DatasetRecord{ID: "a", Path: "/storage/a"})
+	store.AddRecord(&DatasetRecord{ID: "b", Path: "/storage/b"})
+	store.AddRecord(&DatasetRecord{ID: "c", Path: "/storage/a/c"})
+	if got := ids("/storage/a"); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("unexpected records of /storage/a: %v", got)
+	}
+	// moved
+	store.UpdateRecord(&DatasetRecord{ID: "b", Path: "/storage/b2"})
+	if got := ids("/storage/b"); len(got) != 0 {
+		t.Errorf("the old path is still indexed: %v", got)
+	}
+	// replaced .datasetinfo
+	store.WriteBatch(&RecordBatch{Updates: []*DatasetRecord{{ID: "d", Path: "/storage/a"}}})
+	conflicts, _ := store.PathConflicts()
+	if !reflect.DeepEqual(conflicts, map[string][]string{"/storage/a": {"a", "d"}}) {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	}
+	store.DeleteRecord("d")
+	store.SaveArchiveRecord(&DatasetRecord{ID: "c", Path: "/storage/a/c"})
+	if got := ids("/storage/a/c"); len(got) != 0 {
+		t.Errorf("the archived record is still indexed: %v", got)
+	}
+	conflicts, _ = store.PathConflicts()
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	}
+
+	// a database without the index gets it when it's opened
+	store.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(Bucket_Paths))
+	})
+	store.Close()
+	store, err = OpenBoltStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if got := ids("/storage/b2"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("unexpected records of /storage/b2 after the index is built: %v", got)
+	}
+}
@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// the last line of the log of an archive command which succeeded, rebuild-db trusts only these logs
+const archiveSucceeded = "archive result: ok"
+
+// Archiver archives a dataset folder
+type Archiver interface {
+	Archive(policy *Policy, path string, id string) error
+}
+
+// CommandArchiver archives a dataset folder by running the archive command of the policy
+type CommandArchiver struct {
+	LogFolder string // folder to write the output of the command
+}
+
+func (a *CommandArchiver) Archive(policy *Policy, path string, id string) error {
+	archiveCommand := policy.ArchiveCommand
+	if archiveCommand == "" {
+		return errors.New("archive command is empty, this folder should be archived")
+	}
+	return execArchiveCommand(path, id, archiveCommand, a.LogFolder)
+}
+
+func execArchiveCommand(path string, id string, archiveCommand string, logFolder string) error {
+	archiveCommand = strings.Replace(archiveCommand, "${id}", id, -1)
+	archiveCommand = strings.Replace(archiveCommand, "${path}", path, -1)
+	fields, err := getFields(archiveCommand)
+	if err != nil {
+		return err
+	}
+	name := fields[0]
+	args := fields[1:]
+	cmd := exec.Command(name, args...)
+	rc, logErr := getArchiveWriter(logFolder, path, id)
+	defer func() {
+		if rc != nil {
+			rc.Close()
+		}
+	}()
+	if logErr == nil {
+		cmd.Stdout = rc
+	}
+	err = cmd.Run()
+	if rc != nil {
+		if err != nil {
+			fmt.Fprintf(rc, "\narchive result: failed, %v\n", err)
+		} else {
+			fmt.Fprintf(rc, "\n%s\n", archiveSucceeded)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// the runs of a day append to the log of a dataset
+func getLogWriter(logFolder string, fileName string, title string) (io.WriteCloser, error) {
+	logFilePath := filepath.Join(logFolder, fileName)
+	file, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, FileModeCreate)
+	if err != nil {
+		return nil, err
+	}
+	file.WriteString(title)
+	return file, nil
+}
+
+func getArchiveWriter(logFolder string, path string, id string) (io.WriteCloser, error) {
+	logFileName := "archive_" + id + ".log"
+	title := fmt.Sprintf("folder path: %s\n", path)
+	return getLogWriter(logFolder, logFileName, title)
+}
+
+func getFields(str string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(str))
+	r.Comma = ' ' // space
+	fields, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
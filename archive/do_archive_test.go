@@ -0,0 +1,28 @@
+package archive
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDoArchive(t *testing.T) {
+	logFolder := t.TempDir()
+	err := execArchiveCommand("testdata/config-test.yml", "test", "echo \"${path}\"", logFolder)
+	if err != nil {
+		t.Error(err)
+	}
+	// the result of every run of the day is appended to the log
+	if err := execArchiveCommand("testdata/config-test.yml", "test", "false", logFolder); err == nil {
+		t.Errorf("the failed command is not reported")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(logFolder, "archive_test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "folder path: testdata/config-test.yml\ntestdata/config-test.yml\n\n" + archiveSucceeded + "\n" +
+		"folder path: testdata/config-test.yml\n\narchive result: failed, exit status 1\n"
+	if string(data) != expected {
+		t.Errorf("log %q, expected %q", data, expected)
+	}
+}
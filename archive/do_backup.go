@@ -1,4 +1,4 @@
-package main
+package archive
 
 import (
 	"bufio"
@@ -13,12 +13,27 @@ import (
 	"time"
 )
 
-func doBackup(path string) error {
-	backupCommand := appConfig.BackupCommand
-	if backupCommand == "" { // if there's no backup command, skip backup
+// Backupper makes a backup of the files of a dataset folder
+type Backupper interface {
+	// return false if the datasets of the policy are not backed up
+	Enabled(policy *Policy) bool
+	// relativePaths are the updated files and folders, relative to dir
+	Backup(policy *Policy, id string, dir string, relativePaths []string, date time.Time) error
+}
+
+// CommandBackupper makes backups by running the backup command of the policy
+type CommandBackupper struct {
+	LogFolder string // folder to write the output of the command
+}
+
+// make an incremental backup of the dataset,
+// the files modified after the last backup are backed up. The backup time is kept in the record too.
+func (e *Engine) doBackup(policy *Policy, record *DatasetRecord) error {
+	if e.Backupper == nil || policy.NoBackup || !e.Backupper.Enabled(policy) { // if there's no backup, skip backup
 		return nil
 	}
-	info, err := ReadDatasetinfo(path)
+	path := record.Path
+	info, err := ReadDatasetinfo(e.FS, path)
 	if err != nil {
 		return err
 	}
@@ -26,7 +41,13 @@ func doBackup(path string) error {
 		return errors.New(fmt.Sprintf("dataset file %s doesn't exists", DatasetFileName))
 	}
 	backupTime := info.BackupTime
-	relativePaths, maxUpdateTime, fullUpdate, err := getBackupList(path, ".", backupTime)
+	skip := make(map[string]bool)
+	if policy.NestedDatasets != NestedParent { // they have their own records and backups
+		for _, rel := range record.Stats.Nested {
+			skip[rel] = true
+		}
+	}
+	relativePaths, maxUpdateTime, _, err := e.getBackupList(path, ".", backupTime, skip)
 	// maxUpdateTime must not be earlier than backupTime of the last scan
 	if err != nil {
 		return err
@@ -35,9 +56,10 @@ func doBackup(path string) error {
 		maxUpdateTime = backupTime.Time
 	}
 	if len(relativePaths) == 0 {
+		record.BackupTime = backupTime
 		return nil
 	}
-	err = makeBackup(path, info, relativePaths, fullUpdate)
+	err = e.Backupper.Backup(policy, info.ID, path, relativePaths, e.Clock.Now())
 	if err != nil {
 		return err
 	}
@@ -45,13 +67,18 @@ func doBackup(path string) error {
 		Time:  maxUpdateTime,
 		Valid: true,
 	}
-	err = SaveDatasetInfo(path, info)
+	// the record follows the .datasetinfo file, the files are backed up again by the next run if it can't be saved
+	if err := SaveDatasetInfo(e.FS, path, info); err != nil {
+		return fmt.Errorf("the files are backed up, but the backup time can't be saved: %w", err)
+	}
+	record.BackupTime = info.BackupTime
 	return nil
 }
 
-func getBackupList(basePath string, relativePath string, backupTime sql.NullTime) ([]string, time.Time, bool, error) {
+// the folders in skip, relative to basePath, are left out
+func (e *Engine) getBackupList(basePath string, relativePath string, backupTime sql.NullTime, skip map[string]bool) ([]string, time.Time, bool, error) {
 	absPath := filepath.Join(basePath, relativePath)
-	dirs, err := os.ReadDir(absPath)
+	dirs, err := e.FS.ReadDir(absPath)
 	if err != nil {
 		return nil, time.Time{}, false, err
 	}
@@ -59,8 +86,8 @@ func getBackupList(basePath string, relativePath string, backupTime sql.NullTime
 	maxUpdateTime := time.Time{}
 	fullUpdate := true
 	for _, dir := range dirs {
-		info, e := dir.Info()
-		if e != nil {
+		info, e1 := dir.Info()
+		if e1 != nil {
 			continue
 		}
 		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
@@ -73,7 +100,11 @@ func getBackupList(basePath string, relativePath string, backupTime sql.NullTime
 
 		if info.IsDir() {
 			relPath := filepath.Join(relativePath, info.Name())
-			subPaths, subMaxUpdateTime, subFullUpdate, err := getBackupList(basePath, relPath, backupTime)
+			if skip[relPath] {
+				fullUpdate = false
+				continue
+			}
+			subPaths, subMaxUpdateTime, subFullUpdate, err := e.getBackupList(basePath, relPath, backupTime, skip)
 			if err != nil {
 				return nil, time.Time{}, false, err
 			}
@@ -112,33 +143,40 @@ func getBackupList(basePath string, relativePath string, backupTime sql.NullTime
 	return updatedPaths, maxUpdateTime, fullUpdate, nil
 }
 
-func makeBackup(path string, info *Datasetinfo, relativePaths []string, fullUpdate bool) error {
+// backup is enabled if the policy has a backup command
+func (b *CommandBackupper) Enabled(policy *Policy) bool {
+	return policy.BackupCommand != ""
+}
+
+func (b *CommandBackupper) Backup(policy *Policy, id string, dir string, relativePaths []string, date time.Time) error {
 	if len(relativePaths) == 0 {
 		return nil
 	}
 	file, err := os.CreateTemp("", "")
+	if err != nil {
+		return err
+	}
 	datawriter := bufio.NewWriter(file)
 	for _, data := range relativePaths {
 		_, err = datawriter.WriteString(data + "\n")
 		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
 			return err
 		}
 	}
 
 	datawriter.Flush()
 	file.Close()
-	if err != nil {
-		return err
-	}
 
-	date := time.Now().Format("2006-01-02")
-	backupCommand := appConfig.BackupCommand
-	err = execBackupCommand(info.ID, path, file.Name(), date, backupCommand)
+	dateStr := date.Format("2006-01-02")
+	backupCommand := strings.Replace(policy.BackupCommand, "${target}", policy.BackupTarget, -1)
+	err = execBackupCommand(id, dir, file.Name(), dateStr, backupCommand, b.LogFolder)
 	os.Remove(file.Name())
 	return err
 }
 
-func execBackupCommand(id string, dir string, file string, date string, backupCommand string) error {
+func execBackupCommand(id string, dir string, file string, date string, backupCommand string, logFolder string) error {
 	if backupCommand == "" {
 		return nil
 	}
@@ -153,7 +191,7 @@ func execBackupCommand(id string, dir string, file string, date string, backupCo
 	name := fields[0]
 	args := fields[1:]
 	cmd := exec.Command(name, args...)
-	rc, logErr := getBackupWriter(dir, id)
+	rc, logErr := getBackupWriter(logFolder, dir, id)
 	defer func() {
 		if rc != nil {
 			rc.Close()
@@ -169,8 +207,8 @@ func execBackupCommand(id string, dir string, file string, date string, backupCo
 	return nil
 }
 
-func getBackupWriter(path string, id string) (io.WriteCloser, error) {
+func getBackupWriter(logFolder string, path string, id string) (io.WriteCloser, error) {
 	logFileName := "backup_" + id + ".log"
 	title := fmt.Sprintf("folder path: %s\n", path)
-	return getLogWriter(logFileName, title)
+	return getLogWriter(logFolder, logFileName, title)
 }
@@ -0,0 +1,34 @@
+package archive
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// a file system on which the .datasetinfo files can't be written
+type readOnlyFS struct {
+	*MemFS
+}
+
+func (f *readOnlyFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+func TestBackupTimeNotSaved(t *testing.T) {
+	e, sim, _ := newSimulation(t, simTime(0, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := e.Store.ListActiveRecords()
+	record := &records[0]
+	e.FS = &readOnlyFS{MemFS: sim.fsys}
+	policy := e.datasetPolicy(policyOf(e.Config.Policies(), ds1), ds1)
+	if err := e.doBackup(policy, record); err == nil || !strings.Contains(err.Error(), "backup time can't be saved") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if record.BackupTime.Valid {
+		t.Errorf("the backup time %v is set without the %s file", record.BackupTime.Time, DatasetFileName)
+	}
+}
@@ -0,0 +1,167 @@
+// Package archive finds the dataset folders under the storage roots, makes
+// incremental backups of them and archives the folders that are not modified
+// for a configured number of days, after sending notices in advance.
+//
+// The work is done by an Engine. Every side effect of the engine goes through
+// one of its fields, so it can be replaced when the package is embedded in
+// another program or tested.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Engine wires the parts that make up auto archiving
+type Engine struct {
+	Config    *AppConfig
+	Store     Store
+	Archiver  Archiver
+	Backupper Backupper // nil means no backup is made
+	Notifier  Notifier
+	Clock     Clock
+	FS        FileSystem
+	Watcher   *Watcher // follows the changes of the datasets in the watch daemon, nil if they are only walked
+}
+
+// NewEngine creates an engine which works on the real disk
+// and runs the commands of the config.
+func NewEngine(config *AppConfig, store Store) *Engine {
+	e := &Engine{
+		Config:    config,
+		Store:     store,
+		Archiver:  &CommandArchiver{},
+		Backupper: &CommandBackupper{},
+		Notifier:  NewEmailNotifier(config),
+		Clock:     SystemClock{},
+		FS:        OSFS{},
+	}
+	return e
+}
+
+// SetCommandLogFolder makes the archive and backup commands write their output to folder
+func (e *Engine) SetCommandLogFolder(folder string) {
+	if a, ok := e.Archiver.(*CommandArchiver); ok {
+		a.LogFolder = folder
+	}
+	if b, ok := e.Backupper.(*CommandBackupper); ok {
+		b.LogFolder = folder
+	}
+}
+
+// Discover finds the dataset folders under the roots and records them.
+// A root which can't be scanned doesn't stop the discovery of the other roots.
+func (e *Engine) Discover() error {
+	failures := make([]string, 0)
+	for _, policy := range e.Config.Policies() {
+		err := e.ScanFolders(policy)
+		if err != nil {
+			log.Printf("failed to discover datasets in %s, error: %v", policy.Root, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", policy.Root, err))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New("failed to discover datasets in " + strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Scan checks the recorded datasets, makes backups, archives the expired ones
+// and collects the notices to send.
+// Roots which are almost full get datasets picked to be archived early first.
+func (e *Engine) Scan() (*ScanResult, error) {
+	capacityErrors := e.SelectForCapacity()
+	result, err := e.ScanRecords()
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = append(result.Errors, capacityErrors...)
+	return result, nil
+}
+
+// UsageReporter is implemented by the notifiers which can send the usage report
+type UsageReporter interface {
+	SendUsageReport(report *UsageReport) error
+}
+
+// the state key of the month of the last usage report sent by the runs
+const stateUsageReportMonth = "usage-report-month"
+
+const usageReportMonthFormat = "2006-01"
+
+// UsageReportDue returns true if usage-report-day of this month is reached and the usage report of
+// this month isn't sent yet. A run missed on the day sends it later in the month.
+func (e *Engine) UsageReportDue() (bool, error) {
+	now := e.Clock.Now()
+	if e.Config.UsageReportDay == 0 || now.Day() < e.Config.UsageReportDay {
+		return false, nil
+	}
+	sent, err := e.Store.GetState(stateUsageReportMonth)
+	if err != nil {
+		return false, err
+	}
+	return sent < now.Format(usageReportMonthFormat), nil
+}
+
+// SendMonthlyUsageReport sends the usage report if it's due, once a month
+func (e *Engine) SendMonthlyUsageReport() error {
+	due, err := e.UsageReportDue()
+	if err != nil || !due {
+		return err
+	}
+	if err := e.SendUsageReport(); err != nil {
+		return err
+	}
+	return e.Store.SetState(stateUsageReportMonth, e.Clock.Now().Format(usageReportMonthFormat))
+}
+
+// SendUsageReport makes the usage report and sends it by the notifier
+func (e *Engine) SendUsageReport() error {
+	reporter, ok := e.Notifier.(UsageReporter)
+	if !ok {
+		return errors.New("the notifier can't send the usage report")
+	}
+	report, err := e.UsageReport()
+	if err != nil {
+		return err
+	}
+	return reporter.SendUsageReport(report)
+}
+
+// Notify reports the scan result, and marks the notices sent in the records,
+// the notices which are not sent are sent again by the next run.
+func (e *Engine) Notify(scanResult *ScanResult) error {
+	err := e.Notifier.Notify(scanResult)
+	var unsent map[string]bool
+	if notifyErr, ok := err.(*NotifyError); ok {
+		unsent = notifyErr.Unsent
+	} else if err != nil {
+		return err
+	}
+	batch := &RecordBatch{}
+	for _, notice := range scanResult.Notices {
+		if unsent[notice.ID] {
+			continue
+		}
+		record, getErr := e.Store.GetRecord(notice.ID)
+		if getErr != nil {
+			return getErr
+		}
+		if record == nil {
+			continue
+		}
+		record.NoticedLeftDays = notice.NoticeDay
+		batch.Updates = append(batch.Updates, record)
+	}
+	if batch.Len() > 0 {
+		if writeErr := e.Store.WriteBatch(batch); writeErr != nil {
+			log.Printf("failed to mark the notices sent, they are sent again, error: %v", writeErr)
+			if err == nil {
+				err = writeErr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package archive
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// usageReportCounter counts the usage reports sent
+type usageReportCounter struct {
+	*simulation
+	sent int
+	err  error
+}
+
+func (n *usageReportCounter) SendUsageReport(report *UsageReport) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent++
+	return nil
+}
+
+func TestMonthlyUsageReport(t *testing.T) {
+	e, _, clock := newSimulation(t, time.Date(2026, 10, 1, 2, 0, 0, 0, time.Local))
+	e.Config.UsageReportDay = 3
+	notifier := &usageReportCounter{}
+	e.Notifier = notifier
+	run := func(month time.Month, day int) {
+		clock.Set(time.Date(2026, month, day, 2, 0, 0, 0, time.Local))
+		if err := e.SendMonthlyUsageReport(); err != nil && notifier.err == nil {
+			t.Fatal(err)
+		}
+	}
+	run(10, 2)
+	if notifier.sent != 0 {
+		t.Errorf("the usage report is sent before the day")
+	}
+	// a second run on the day, e.g. after a restart of the watch daemon
+	run(10, 3)
+	run(10, 3)
+	if notifier.sent != 1 {
+		t.Errorf("the usage report is sent %d times on the day", notifier.sent)
+	}
+	// the run of the day is missed, or the email fails
+	notifier.err = fmt.Errorf("smtp server down")
+	run(11, 4)
+	notifier.err = nil
+	run(11, 5)
+	run(11, 6)
+	if notifier.sent != 2 {
+		t.Errorf("the usage report of the month is sent %d times", notifier.sent-1)
+	}
+}
@@ -0,0 +1,299 @@
+package archive
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// number of records written in one transaction by an import
+const importBatchSize = 1000
+
+// ExportFormats are the formats of ExportRecords and ImportRecords
+var ExportFormats = []string{"jsonl", "csv"}
+
+// ExportedRecord is a line of the jsonl export
+type ExportedRecord struct {
+	Bucket string         `json:"bucket"`
+	Record *DatasetRecord `json:"record"`
+}
+
+// the columns of the csv export, the lists are json values
+var exportColumns = []string{
+	"bucket", "id", "path", "last_modify_time", "activity_signal", "scan_time", "noticed_left_days",
+	"archive_time", "capacity_deadline", "backup_time", "dirty", "unreadable", "owner_uid", "owner_gid",
+	"stats_time", "bytes", "allocated", "files", "dirs", "largest_files", "nested",
+	"copied_from", "path_history", "missing_since", "vanish_time",
+}
+
+// ExportRecords writes the records of all the buckets, one record per line or row
+func ExportRecords(store Store, w io.Writer, format string) error {
+	var write func(bucket string, record *DatasetRecord) error
+	var flush func() error
+	switch format {
+	case "jsonl":
+		out := bufio.NewWriter(w)
+		enc := json.NewEncoder(out)
+		write = func(bucket string, record *DatasetRecord) error {
+			return enc.Encode(ExportedRecord{Bucket: bucket, Record: record})
+		}
+		flush = out.Flush
+	case "csv":
+		out := csv.NewWriter(w)
+		if err := out.Write(exportColumns); err != nil {
+			return err
+		}
+		write = func(bucket string, record *DatasetRecord) error {
+			row, err := recordRow(bucket, record)
+			if err != nil {
+				return err
+			}
+			return out.Write(row)
+		}
+		flush = func() error {
+			out.Flush()
+			return out.Error()
+		}
+	default:
+		return errors.Errorf("unknown export format %s", format)
+	}
+	for _, bucket := range RecordBuckets {
+		err := store.EachRecord(bucket, func(record *DatasetRecord) error {
+			return write(bucket, record)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to export the %s records", bucket)
+		}
+	}
+	return flush()
+}
+
+// ImportRecords reads the records written by ExportRecords into an empty store.
+// It returns the number of records imported. The records are saved in batches, the records saved
+// before an error are kept and the database must be deleted to import again.
+func ImportRecords(store Store, r io.Reader, format string) (int, error) {
+	if empty, err := storeEmpty(store); err != nil {
+		return 0, err
+	} else if !empty {
+		return 0, errors.New("the database is not empty, records can only be imported into a new database")
+	}
+	var read func() (string, *DatasetRecord, error)
+	switch format {
+	case "jsonl":
+		dec := json.NewDecoder(r)
+		read = func() (string, *DatasetRecord, error) {
+			var line ExportedRecord
+			if err := dec.Decode(&line); err != nil {
+				return "", nil, err
+			}
+			if line.Record == nil {
+				return "", nil, errors.New("a line has no record")
+			}
+			return line.Bucket, line.Record, nil
+		}
+	case "csv":
+		in := csv.NewReader(r)
+		header, err := in.Read()
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to read the csv header")
+		}
+		// the columns are read by their position
+		if len(header) != len(exportColumns) {
+			return 0, errors.Errorf("the csv header has %d columns, an export has %d", len(header), len(exportColumns))
+		}
+		for i, column := range exportColumns {
+			if header[i] != column {
+				return 0, errors.Errorf("column %d of the csv header is %q, %q in an export", i+1, header[i], column)
+			}
+		}
+		read = func() (string, *DatasetRecord, error) {
+			row, err := in.Read()
+			if err != nil {
+				return "", nil, err
+			}
+			return rowRecord(row)
+		}
+	default:
+		return 0, errors.Errorf("unknown import format %s", format)
+	}
+
+	// every record goes into its own bucket as it is: an id can be active and archived,
+	// when an archived dataset is restored
+	count, err := importRecords(store, read)
+	if err != nil && count > 0 {
+		err = errors.Wrapf(err, "%d records are saved, delete the database before importing again", count)
+	}
+	return count, err
+}
+
+func importRecords(store Store, read func() (string, *DatasetRecord, error)) (int, error) {
+	count := 0
+	pending := 0
+	byBucket := make(map[string][]*DatasetRecord, len(RecordBuckets))
+	write := func() error {
+		for _, bucket := range RecordBuckets {
+			if err := store.PutRecords(bucket, byBucket[bucket]); err != nil {
+				return errors.Wrapf(err, "failed to save %d %s records", len(byBucket[bucket]), bucket)
+			}
+			count += len(byBucket[bucket])
+			byBucket[bucket] = nil
+		}
+		pending = 0
+		return nil
+	}
+	for {
+		bucket, record, err := read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, errors.Wrapf(err, "failed to read record %d", count+pending+1)
+		}
+		if !containsString(RecordBuckets, bucket) {
+			return count, errors.Errorf("record %s is in the unknown bucket %q", record.ID, bucket)
+		}
+		byBucket[bucket] = append(byBucket[bucket], record)
+		pending++
+		if pending >= importBatchSize {
+			if err := write(); err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, write()
+}
+
+// if the store has no records in any bucket
+func storeEmpty(store Store) (bool, error) {
+	empty := true
+	for _, bucket := range RecordBuckets {
+		err := store.EachRecord(bucket, func(record *DatasetRecord) error {
+			empty = false
+			return io.EOF
+		})
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+	}
+	return empty, nil
+}
+
+func recordRow(bucket string, r *DatasetRecord) ([]string, error) {
+	largestFiles, err := json.Marshal(r.Stats.LargestFiles)
+	if err != nil {
+		return nil, err
+	}
+	nested, err := json.Marshal(r.Stats.Nested)
+	if err != nil {
+		return nil, err
+	}
+	pathHistory, err := json.Marshal(r.PathHistory)
+	if err != nil {
+		return nil, err
+	}
+	uid, gid := "", ""
+	if r.Owner != nil {
+		uid, gid = strconv.Itoa(r.Owner.UID), strconv.Itoa(r.Owner.GID)
+	}
+	statsTime := ""
+	if !r.Stats.Time.IsZero() {
+		statsTime = r.Stats.Time.Format(time.RFC3339Nano)
+	}
+	return []string{
+		bucket, r.ID, r.Path, formatNullTime(r.LastModifyTime), r.ActivitySignal, formatNullTime(r.ScanTime),
+		strconv.Itoa(r.NoticedLeftDays), formatNullTime(r.ArchiveTime), formatNullTime(r.CapacityDeadline),
+		formatNullTime(r.BackupTime), strconv.FormatBool(r.Dirty), strconv.Itoa(r.Unreadable), uid, gid,
+		statsTime, strconv.FormatInt(r.Stats.Bytes, 10), strconv.FormatInt(r.Stats.Allocated, 10),
+		strconv.Itoa(r.Stats.Files), strconv.Itoa(r.Stats.Dirs), string(largestFiles), string(nested),
+		r.CopiedFrom, string(pathHistory), formatNullTime(r.MissingSince), formatNullTime(r.VanishTime),
+	}, nil
+}
+
+func rowRecord(row []string) (string, *DatasetRecord, error) {
+	col := make(map[string]string, len(exportColumns))
+	for i, name := range exportColumns {
+		col[name] = row[i]
+	}
+	// the first error is kept, the other values are still parsed
+	var err error
+	atoi := func(name string) int {
+		n, e := strconv.Atoi(col[name])
+		if e != nil && err == nil {
+			err = errors.Wrap(e, name)
+		}
+		return n
+	}
+	parseInt := func(name string) int64 {
+		n, e := strconv.ParseInt(col[name], 10, 64)
+		if e != nil && err == nil {
+			err = errors.Wrap(e, name)
+		}
+		return n
+	}
+	nullTime := func(name string) sql.NullTime {
+		t, e := parseNullTime(col[name])
+		if e != nil && err == nil {
+			err = errors.Wrap(e, name)
+		}
+		return t
+	}
+	unmarshal := func(name string, v interface{}) {
+		if e := json.Unmarshal([]byte(col[name]), v); e != nil && err == nil {
+			err = errors.Wrap(e, name)
+		}
+	}
+	r := &DatasetRecord{
+		ID:               col["id"],
+		Path:             col["path"],
+		LastModifyTime:   nullTime("last_modify_time"),
+		ActivitySignal:   col["activity_signal"],
+		ScanTime:         nullTime("scan_time"),
+		NoticedLeftDays:  atoi("noticed_left_days"),
+		ArchiveTime:      nullTime("archive_time"),
+		CapacityDeadline: nullTime("capacity_deadline"),
+		BackupTime:       nullTime("backup_time"),
+		Dirty:            col["dirty"] == "true",
+		Unreadable:       atoi("unreadable"),
+		CopiedFrom:       col["copied_from"],
+		MissingSince:     nullTime("missing_since"),
+		VanishTime:       nullTime("vanish_time"),
+	}
+	if col["owner_uid"] != "" {
+		r.Owner = &Owner{UID: atoi("owner_uid"), GID: atoi("owner_gid")}
+	}
+	r.Stats.Time = nullTime("stats_time").Time
+	r.Stats.Bytes = parseInt("bytes")
+	r.Stats.Allocated = parseInt("allocated")
+	r.Stats.Files = atoi("files")
+	r.Stats.Dirs = atoi("dirs")
+	unmarshal("largest_files", &r.Stats.LargestFiles)
+	unmarshal("nested", &r.Stats.Nested)
+	unmarshal("path_history", &r.PathHistory)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "invalid value in the row of %s", r.ID)
+	}
+	return col["bucket"], r, nil
+}
+
+func formatNullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339Nano)
+}
+
+func parseNullTime(s string) (sql.NullTime, error) {
+	if s == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	day := func(d int) sql.NullTime { return sql.NullTime{Time: simTime(d, 2), Valid: true} }
+	active := &DatasetRecord{
+		ID: "a", Path: "/storage/a, \"quoted\"", LastModifyTime: day(1), ScanTime: day(2), NoticedLeftDays: 5,
+		BackupTime: day(1), Dirty: true, ActivitySignal: SignalCtime, Owner: &Owner{UID: 1000, GID: 100},
+		Stats: DatasetStats{Time: simTime(2, 2), Bytes: 3000, Allocated: 4096, Files: 2, Dirs: 1,
+			LargestFiles: []FileSize{{Path: "frames/1.tif", Size: 2000}, {Path: "x", Size: 1000}}, Nested: []string{"run1"}},
+		CopiedFrom: "b", PathHistory: []PathChange{{Path: "/storage/old", Until: simTime(1, 2)}},
+	}
+	archived := &DatasetRecord{ID: "b", Path: "/storage/b", LastModifyTime: day(0), ArchiveTime: day(30)}
+	vanished := &DatasetRecord{ID: "c", Path: "/storage/c", MissingSince: day(3), VanishTime: day(10)}
+	if err := store.WriteBatch(&RecordBatch{Updates: []*DatasetRecord{active}, Archived: []*DatasetRecord{archived}, Vanished: []*DatasetRecord{vanished}}); err != nil {
+		t.Fatal(err)
+	}
+	// an archived dataset restored from the backup, and a vanished dataset of which a copy is active
+	if err := store.PutRecords(Bucket_Active, []*DatasetRecord{{ID: "b", Path: "/storage/b", LastModifyTime: day(40)}, {ID: "c", Path: "/storage/c2"}}); err != nil {
+		t.Fatal(err)
+	}
+	list := func(s Store) [][]DatasetRecord {
+		a, _ := s.ListActiveRecords()
+		b, _ := s.ListArchivedRecords()
+		c, _ := s.ListVanishedRecords()
+		return [][]DatasetRecord{a, b, c}
+	}
+	for _, format := range ExportFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf strings.Builder
+			if err := ExportRecords(store, &buf, format); err != nil {
+				t.Fatal(err)
+			}
+			imported, err := OpenBoltStore(filepath.Join(t.TempDir(), "archive.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer imported.Close()
+			count, err := ImportRecords(imported, strings.NewReader(buf.String()), format)
+			if err != nil || count != 5 {
+				t.Fatalf("%d records imported, err: %v", count, err)
+			}
+			if lists := list(imported); len(lists[0]) != 3 || len(lists[1]) != 1 || len(lists[2]) != 1 {
+				t.Errorf("an id in two buckets is not imported into both: %+v", lists)
+			}
+			if !reflect.DeepEqual(list(imported), list(store)) {
+				t.Errorf("imported records:\n%+v\nexpected:\n%+v", list(imported), list(store))
+			}
+			if found, _ := imported.GetRecordsByPath(active.Path); len(found) != 1 {
+				t.Errorf("the path index is not built by the import")
+			}
+			if _, err := ImportRecords(imported, strings.NewReader(buf.String()), format); err == nil {
+				t.Errorf("records imported into a database which is not empty")
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	open := func(t *testing.T) Store {
+		store, err := OpenBoltStore(filepath.Join(t.TempDir(), "archive.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	// the same columns in another order
+	swapped := append([]string{}, exportColumns...)
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	csv := strings.Join(swapped, ",") + "\nactive,/storage/a,a" + strings.Repeat(",", len(exportColumns)-3) + "\n"
+	store := open(t)
+	if _, err := ImportRecords(store, strings.NewReader(csv), "csv"); err == nil || !strings.Contains(err.Error(), `column 2 of the csv header is "path"`) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if empty, _ := storeEmpty(store); !empty {
+		t.Errorf("records imported with a wrong header")
+	}
+
+	// a broken line after the first batch
+	var jsonl strings.Builder
+	for i := 0; i < importBatchSize; i++ {
+		fmt.Fprintf(&jsonl, `{"bucket":"active","record":{"ID":"id%d","Path":"/storage/ds%d"}}`+"\n", i, i)
+	}
+	jsonl.WriteString("{broken\n")
+	count, err := ImportRecords(open(t), strings.NewReader(jsonl.String()), "jsonl")
+	if count != importBatchSize || err == nil || !strings.Contains(err.Error(), "delete the database before importing again") {
+		t.Errorf("%d records imported, error %v", count, err)
+	}
+}
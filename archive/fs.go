@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// FileSystem is the set of file system operations the engine needs.
+// Paths are native paths, the same as accepted by the os package.
+type FileSystem interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// the size of the file system containing name
+	Usage(name string) (DiskUsage, error)
+}
+
+// DiskUsage is the size of a file system in bytes
+type DiskUsage struct {
+	Total uint64
+	Free  uint64 // free space for a normal user
+}
+
+// UsedPercent returns how full the file system is, from 0 to 100
+func (u DiskUsage) UsedPercent() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+	return float64(u.Total-u.Free) * 100 / float64(u.Total)
+}
+
+// OSFS is the FileSystem backed by the real disk
+type OSFS struct{}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
@@ -0,0 +1,14 @@
+package archive
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+func sysFileTimes(info fs.FileInfo) (ctime time.Time, atime time.Time) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctim.Unix()), time.Unix(st.Atim.Unix())
+	}
+	return time.Time{}, time.Time{}
+}
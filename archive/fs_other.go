@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package archive
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+func (OSFS) Usage(name string) (DiskUsage, error) {
+	return DiskUsage{}, errors.New("disk usage is not supported on this system")
+}
+
+func allocatedSize(info fs.FileInfo) int64 {
+	return info.Size()
+}
+
+func ownerOf(info fs.FileInfo) *Owner {
+	return nil
+}
+
+func sysFileTimes(info fs.FileInfo) (ctime time.Time, atime time.Time) {
+	return time.Time{}, time.Time{}
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package archive
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func (OSFS) Usage(name string) (DiskUsage, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(name, &st)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{
+		Total: st.Blocks * uint64(st.Bsize),
+		Free:  st.Bavail * uint64(st.Bsize),
+	}, nil
+}
+
+// the space allocated for a file, the size if it's not known
+func allocatedSize(info fs.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512
+	}
+	return info.Size()
+}
+
+// the owner of a file, nil if it's not known
+func ownerOf(info fs.FileInfo) *Owner {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return &Owner{UID: int(st.Uid), GID: int(st.Gid)}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+type InspectResult struct {
+	Active   []DatasetRecord
+	Archived []DatasetRecord
+	Vanished []DatasetRecord
+	// effective policy of the active datasets by id, the Sources tell where each value comes from
+	Policies map[string]*Policy
+	// paths claimed by several active records, with their ids
+	Conflicts map[string][]string
+}
+
+func (e *Engine) Inspect() (*InspectResult, error) {
+	active, err := e.Store.ListActiveRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading active records")
+	}
+	archived, err := e.Store.ListArchivedRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading archived records")
+	}
+	vanished, err := e.Store.ListVanishedRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading vanished records")
+	}
+	conflicts, err := e.Store.PathConflicts()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the path index")
+	}
+	policies := e.Config.Policies()
+	datasetPolicies := make(map[string]*Policy)
+	for _, record := range active {
+		policy := policyOf(policies, record.Path)
+		if policy == nil {
+			continue
+		}
+		datasetPolicies[record.ID] = e.datasetPolicy(policy, record.Path)
+	}
+	result := InspectResult{
+		Active:    active,
+		Archived:  archived,
+		Vanished:  vanished,
+		Policies:  datasetPolicies,
+		Conflicts: conflicts,
+	}
+	return &result, nil
+}
+
+// FindRecords returns the active, archived or vanished record of an id, or the active records of a path.
+// A path gives several records if they claim it.
+func (e *Engine) FindRecords(idOrPath string) ([]DatasetRecord, error) {
+	record, err := e.Store.GetRecord(idOrPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading active records")
+	}
+	if record != nil {
+		return []DatasetRecord{*record}, nil
+	}
+	records, err := e.Store.GetRecordsByPath(filepath.Clean(idOrPath))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the path index")
+	}
+	if len(records) > 0 {
+		return records, nil
+	}
+	archived, err := e.Store.ListArchivedRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading archived records")
+	}
+	vanished, err := e.Store.ListVanishedRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading vanished records")
+	}
+	for _, r := range append(archived, vanished...) {
+		if r.ID == idOrPath {
+			return []DatasetRecord{r}, nil
+		}
+	}
+	return records, nil
+}
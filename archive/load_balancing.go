@@ -0,0 +1,39 @@
+package archive
+
+import (
+	"database/sql"
+)
+
+// LoadBalancing spreads the scan time of the records over the scan interval of their root,
+// so that every run only scans a part of the records.
+func (e *Engine) LoadBalancing() error {
+	err := e.Discover()
+	if err != nil {
+		return err
+	}
+	list, err := e.Store.ListActiveRecords()
+	if err != nil {
+		return err
+	}
+	policies := e.Config.Policies()
+	counts := make(map[*Policy]int) // records already spread in each root
+	now := e.Clock.Now()
+	for _, r := range list {
+		policy := policyOf(policies, r.Path)
+		if policy == nil || policy.ScanInterval <= 1 {
+			continue
+		}
+		i := counts[policy]
+		counts[policy] = i + 1
+		addDays := (i % policy.ScanInterval) + 1
+		r.ScanTime = sql.NullTime{
+			Time:  now.AddDate(0, 0, -addDays),
+			Valid: true,
+		}
+		err = e.Store.UpdateRecord(&r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package archive
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is a FileSystem kept in memory, to run the engine without a real disk.
+// Paths must be absolute, MemFS has no symbolic links.
+type MemFS struct {
+	Clock    Clock  // time stamp of files written by WriteFile
+	Capacity uint64 // total size reported by Usage, the files take the used part
+
+	mu   sync.RWMutex
+	root *memNode
+}
+
+type memNode struct {
+	name     string
+	mode     fs.FileMode
+	modTime  time.Time
+	ctime    time.Time // zero for the modify time
+	atime    time.Time
+	data     []byte
+	children map[string]*memNode // nil for files
+}
+
+func NewMemFS(clock Clock) *MemFS {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &MemFS{
+		Clock: clock,
+		root:  &memNode{name: "/", mode: fs.ModeDir | FolderModeCreate, children: map[string]*memNode{}},
+	}
+}
+
+func splitPath(name string) []string {
+	name = filepath.Clean(name)
+	if name == "/" {
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(name, "/"), "/")
+}
+
+func (m *MemFS) lookup(op string, name string) (*memNode, error) {
+	node := m.root
+	for _, part := range splitPath(name) {
+		if node.children == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+		}
+		child, ok := node.children[part]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// lookup the parent folder of name, create it if mkdir is true
+func (m *MemFS) lookupParent(op string, name string, mkdir bool, modTime time.Time) (*memNode, string, error) {
+	parts := splitPath(name)
+	if len(parts) == 0 {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node := m.root
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := node.children[part]
+		if !ok {
+			if !mkdir {
+				return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			child = &memNode{name: part, mode: fs.ModeDir | FolderModeCreate, modTime: modTime, children: map[string]*memNode{}}
+			node.children[part] = child
+			node.modTime = modTime
+		}
+		if child.children == nil {
+			return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+		}
+		node = child
+	}
+	return node, parts[len(parts)-1], nil
+}
+
+func (n *memNode) info() fs.FileInfo {
+	st := &memStat{ctime: n.ctime, atime: n.atime}
+	if st.ctime.Before(n.modTime) {
+		st.ctime = n.modTime
+	}
+	return &memFileInfo{name: n.name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime, sys: st}
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	return m.Lstat(name)
+}
+
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, err := m.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.children == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries := make([]fs.DirEntry, 0, len(node.children))
+	for _, child := range node.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.children != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	data := make([]byte, len(node.data))
+	copy(data, node.data)
+	return data, nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return m.writeFile("open", name, data, perm, false, m.Clock.Now())
+}
+
+// AddFile creates or overwrites a file with the modify time, the parent folders are created when missing.
+func (m *MemFS) AddFile(name string, data []byte, modTime time.Time) error {
+	return m.writeFile("open", name, data, FileModeCreate, true, modTime)
+}
+
+func (m *MemFS) writeFile(op string, name string, data []byte, perm fs.FileMode, mkdir bool, modTime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parent, base, err := m.lookupParent(op, name, mkdir, modTime)
+	if err != nil {
+		return err
+	}
+	existing, ok := parent.children[base]
+	if ok && existing.children != nil {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	}
+	if !ok { // like a real disk, adding an entry modifies the folder
+		parent.modTime = modTime
+	}
+	content := make([]byte, len(data))
+	copy(content, data)
+	parent.children[base] = &memNode{name: base, mode: perm, modTime: modTime, data: content}
+	return nil
+}
+
+// MkdirAll creates a folder and its missing parents with the modify time
+func (m *MemFS) MkdirAll(name string, modTime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts := splitPath(name)
+	node := m.root
+	for _, part := range parts {
+		child, ok := node.children[part]
+		if !ok {
+			child = &memNode{name: part, mode: fs.ModeDir | FolderModeCreate, modTime: modTime, children: map[string]*memNode{}}
+			node.children[part] = child
+			node.modTime = modTime
+		}
+		if child.children == nil {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+		}
+		node = child
+	}
+	return nil
+}
+
+// Chtimes changes the modify time of a file or folder, like touch -d or cp -p the change time is now
+func (m *MemFS) Chtimes(name string, modTime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, err := m.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	node.modTime = modTime
+	node.ctime = m.Clock.Now()
+	return nil
+}
+
+// Access sets the access time of a file or folder, as if it's read
+func (m *MemFS) Access(name string, atime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, err := m.lookup("access", name)
+	if err != nil {
+		return err
+	}
+	node.atime = atime
+	return nil
+}
+
+// Usage reports the size of the whole MemFS, whatever the name is
+func (m *MemFS) Usage(name string) (DiskUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, err := m.lookup("statfs", name); err != nil {
+		return DiskUsage{}, err
+	}
+	used := m.root.size()
+	if used > m.Capacity {
+		used = m.Capacity
+	}
+	return DiskUsage{Total: m.Capacity, Free: m.Capacity - used}, nil
+}
+
+func (n *memNode) size() uint64 {
+	size := uint64(len(n.data))
+	for _, child := range n.children {
+		size += child.size()
+	}
+	return size
+}
+
+// RemoveAll removes a file or a folder with everything inside,
+// it's not an error if the path doesn't exist.
+func (m *MemFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parent, base, err := m.lookupParent("remove", name, false, time.Time{})
+	if err != nil {
+		if pathErr, ok := err.(*fs.PathError); ok && pathErr.Err == fs.ErrNotExist {
+			return nil
+		}
+		return err
+	}
+	if _, ok := parent.children[base]; ok {
+		delete(parent.children, base)
+		parent.modTime = m.Clock.Now()
+	}
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     *memStat
+}
+
+// memStat is the Sys of a memFileInfo
+type memStat struct {
+	ctime time.Time
+	atime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() interface{}   { return i.sys }
@@ -0,0 +1,119 @@
+package archive
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// what happens to a dataset folder inside another dataset, see NestedModes
+const (
+	NestedParent = "parent" // the nested folder is a part of the outer dataset, it has no record of its own
+	NestedChild  = "child"  // the nested folder is a dataset of its own, the outer dataset leaves it out
+	NestedError  = "error"  // like child, and the nesting is reported until it's resolved
+)
+
+var NestedModes = []string{NestedParent, NestedChild, NestedError}
+
+// if the folder listing makes a folder inside a dataset a dataset of its own:
+// it has a .datasetinfo file or one of the character folders
+func isNestedDataset(entries []fs.DirEntry, characterFolders []string) bool {
+	for _, entry := range entries {
+		if entry.Name() == DatasetFileName && !entry.IsDir() {
+			return true
+		}
+		if entry.IsDir() && containsString(characterFolders, entry.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// the path of the closest active dataset containing the path, empty if none
+func outerDataset(paths map[string]bool, path string, root string) string {
+	for dir := filepath.Dir(path); dir != path && isUnder(dir, root); path, dir = dir, filepath.Dir(dir) {
+		if paths[dir] {
+			return dir
+		}
+	}
+	return ""
+}
+
+// check the records inside another active dataset, and return the records to scan.
+// With the parent mode the record of the nested dataset is deleted, with the error mode it's reported.
+func (e *Engine) checkNestedRecords(policies []*Policy, records []DatasetRecord, result *ScanResult) ([]DatasetRecord, error) {
+	paths := make(map[string]bool, len(records))
+	for _, r := range records {
+		paths[r.Path] = true
+	}
+	toScan := make([]DatasetRecord, 0, len(records))
+	absorbed := make([]string, 0)
+	for _, r := range records {
+		policy := policyOf(policies, r.Path)
+		outer := ""
+		if policy != nil {
+			outer = outerDataset(paths, r.Path, policy.Root)
+		}
+		if outer == "" || policy.NestedDatasets == NestedChild {
+			toScan = append(toScan, r)
+			continue
+		}
+		if policy.NestedDatasets == NestedError {
+			result.Errors = append(result.Errors, ScanError{Root: policy.Root, ID: r.ID, Path: r.Path,
+				Msg: "the dataset is inside the dataset " + outer + ", set nested-datasets or remove one of them"})
+			toScan = append(toScan, r)
+			continue
+		}
+		// the data is still on disk as a part of the outer dataset, it's not vanished.
+		// The id and the backup time stay in its .datasetinfo file.
+		log.Printf("dataset %s, %s is inside the dataset %s, delete its record, backup time %v", r.ID, r.Path, outer, r.BackupTime.Time)
+		result.Errors = append(result.Errors, ScanError{Root: policy.Root, ID: r.ID, Path: r.Path,
+			Msg: "the dataset is inside the dataset " + outer + ", its record is deleted, it's archived with " + outer})
+		absorbed = append(absorbed, r.ID)
+	}
+	if len(absorbed) > 0 {
+		if err := e.Store.WriteBatch(&RecordBatch{Deletes: absorbed}); err != nil {
+			return nil, errors.Wrap(err, "failed to delete the records of the nested datasets")
+		}
+	}
+	return toScan, nil
+}
+
+// handle the nested datasets found by the last full walk of the dataset.
+// With the child and the error modes they get their own records, the error mode reports them too.
+func (e *Engine) handleNested(policy *Policy, record *DatasetRecord, c *chan ScanResultModifier) {
+	if policy.NestedDatasets == NestedParent {
+		return
+	}
+	for _, rel := range record.Stats.Nested {
+		path := filepath.Join(record.Path, rel)
+		if policy.NestedDatasets == NestedError {
+			addErrResult(policy.Root, record.ID, record.Path, errors.Errorf(
+				"the dataset contains the dataset %s, set nested-datasets or remove one of them", rel), c)
+		}
+		known, err := e.Store.GetRecordsByPath(path)
+		if err != nil || len(known) > 0 {
+			continue
+		}
+		_, nested, err := e.findDataset(policy, path)
+		if err != nil {
+			addErrResult(policy.Root, record.ID, path, errors.Wrap(err, "can't add the nested dataset"), c)
+			continue
+		}
+		if nested != nil {
+			log.Printf("dataset %s found inside the dataset %s", path, record.Path)
+			saveRecord(nested, c)
+		}
+	}
+}
+
+// the nested datasets which must not be archived with the dataset, empty with the parent mode
+func keptNested(policy *Policy, record *DatasetRecord) string {
+	if policy.NestedDatasets == NestedParent {
+		return ""
+	}
+	return strings.Join(record.Stats.Nested, ", ")
+}
@@ -0,0 +1,148 @@
+package archive
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNestedDatasets(t *testing.T) {
+	project := "/storage/scratch/user2/project"
+	run1 := filepath.Join(project, "run1")
+	setup := func(t *testing.T, mode string) (*Engine, *simulation, func(day int) *ScanResult) {
+		e, sim, clock := newSimulation(t, simTime(0, 2))
+		addFrames(t, sim.fsys, project, simTime(0, 0), "notes.txt", "run1/frames/1.tif")
+		e.Config.NestedDatasets = mode
+		run := func(day int) *ScanResult {
+			sim.day = day
+			clock.Set(simTime(day, 2))
+			if err := e.Discover(); err != nil {
+				t.Fatal(err)
+			}
+			result, err := e.Scan()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return result
+		}
+		return e, sim, run
+	}
+	recordOf := func(t *testing.T, e *Engine, path string) *DatasetRecord {
+		records, err := e.Store.GetRecordsByPath(path)
+		if err != nil || len(records) > 1 {
+			t.Fatalf("unexpected records of %s: %+v, %v", path, records, err)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return &records[0]
+	}
+	errorsOf := func(result *ScanResult) []string {
+		messages := make([]string, 0)
+		for _, e := range result.Errors {
+			messages = append(messages, e.Path+": "+e.Msg)
+		}
+		sort.Strings(messages)
+		return messages
+	}
+
+	t.Run("child", func(t *testing.T) {
+		e, sim, run := setup(t, NestedChild)
+		run(0)
+		parent := recordOf(t, e, project)
+		if parent == nil || parent.Stats.Files != 1 || !reflect.DeepEqual(parent.Stats.Nested, []string{"run1"}) {
+			t.Errorf("unexpected record of the outer dataset %+v", parent)
+		}
+		if recordOf(t, e, run1) == nil {
+			t.Errorf("the nested dataset has no record")
+		}
+		run(1)
+		expected := []string{"day 00: backup " + project + " notes.txt", "day 01: backup " + run1 + " frames"}
+		if !reflect.DeepEqual(sim.events, expected) {
+			t.Errorf("unexpected events %v", sim.events)
+		}
+		// the nested dataset is in use, the outer one is not archived
+		clock := e.Clock.(*ManualClock)
+		clock.Set(simTime(29, 1))
+		addFrames(t, sim.fsys, run1, simTime(29, 1), "frames/2.tif")
+		result := run(30)
+		expectedErrors := []string{project + ": the dataset is not archived, it contains the datasets run1"}
+		if !reflect.DeepEqual(errorsOf(result), expectedErrors) || len(result.ArchivedFolders) != 0 {
+			t.Errorf("unexpected errors %v and archived folders %+v", errorsOf(result), result.ArchivedFolders)
+		}
+	})
+
+	t.Run("parent", func(t *testing.T) {
+		e, sim, run := setup(t, NestedParent)
+		sim.day = 0
+		if err := e.Discover(); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.AddDataset(run1); err != nil { // found before the outer dataset
+			t.Fatal(err)
+		}
+		result := run(0)
+		expectedErrors := []string{run1 + ": the dataset is inside the dataset " + project + ", its record is deleted, it's archived with " + project}
+		if !reflect.DeepEqual(errorsOf(result), expectedErrors) {
+			t.Errorf("unexpected errors %v", errorsOf(result))
+		}
+		if recordOf(t, e, run1) != nil {
+			t.Errorf("the record of the nested dataset is still active")
+		}
+		// its data is still on disk, it's not reported as vanished
+		if vanished, _ := e.Store.ListVanishedRecords(); len(vanished) != 0 {
+			t.Errorf("the record of the nested dataset is in the vanished records: %+v", vanished)
+		}
+		parent := recordOf(t, e, project)
+		if parent.Stats.Files != 2 || !reflect.DeepEqual(parent.Stats.Nested, []string{"run1"}) {
+			t.Errorf("unexpected stats of the outer dataset %+v", parent.Stats)
+		}
+		if !reflect.DeepEqual(sim.events, []string{"day 00: backup " + project + " notes.txt,run1"}) {
+			t.Errorf("unexpected events %v", sim.events)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		e, sim, run := setup(t, NestedError)
+		if err := e.Discover(); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.AddDataset(run1); err != nil {
+			t.Fatal(err)
+		}
+		result := run(0)
+		// the nested dataset is backed up and counted by its own record only
+		sort.Strings(sim.events)
+		expected := []string{"day 00: backup " + project + " notes.txt", "day 00: backup " + run1 + " frames"}
+		if !reflect.DeepEqual(sim.events, expected) {
+			t.Errorf("unexpected events %v", sim.events)
+		}
+		if parent := recordOf(t, e, project); parent == nil || parent.Stats.Files != 1 {
+			t.Errorf("unexpected record of the outer dataset %+v", parent)
+		}
+		expectedErrors := []string{
+			run1 + ": the dataset is inside the dataset " + project + ", set nested-datasets or remove one of them",
+			project + ": the dataset contains the dataset run1, set nested-datasets or remove one of them",
+		}
+		if !reflect.DeepEqual(errorsOf(result), expectedErrors) {
+			t.Errorf("unexpected errors %v", errorsOf(result))
+		}
+		if recordOf(t, e, run1) == nil || recordOf(t, e, project) == nil {
+			t.Errorf("the records are not kept")
+		}
+	})
+
+	t.Run("error without a record of the nested dataset", func(t *testing.T) {
+		e, sim, run := setup(t, NestedError)
+		run(0)
+		if recordOf(t, e, run1) == nil {
+			t.Errorf("the nested dataset has no record")
+		}
+		run(1)
+		expected := []string{"day 00: backup " + project + " notes.txt", "day 01: backup " + run1 + " frames"}
+		if !reflect.DeepEqual(sim.events, expected) {
+			t.Errorf("unexpected events %v", sim.events)
+		}
+	})
+}
@@ -0,0 +1,348 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// Sections have a title only when the report covers several roots,
+// then the title is the first level heading.
+const tpl = `{{range .Sections}}{{if .Title}}
+<h1>{{ .Title }}</h1>{{end}}
+<{{$.Heading}}>Directories to be archived</{{$.Heading}}>
+{{range .Notices}}<p>{{ .Path }} will be archived in {{ .DaysBeforeArchive }} days{{if .Capacity}} to free space, the storage is almost full{{end}}{{if .Bytes}}, it will free {{ bytes .Bytes }}{{end}}</p>{{end}}
+<{{$.Heading}}>Directories archived today</{{$.Heading}}>
+{{range .ArchivedFolders}}<p>{{ .Path }}{{if .Bytes}} ({{ bytes .Bytes }}){{end}}</p>{{end}}
+{{if .Copies}}<{{$.Heading}}>Copied directories</{{$.Heading}}>
+{{range .Copies}}<p>{{ .Path }} is a copy of {{if .FromPath}}{{ .FromPath }}{{else}}dataset {{ .FromID }}{{end}}, it's a new dataset</p>{{end}}
+{{end}}{{if .Moves}}<{{$.Heading}}>Moved directories</{{$.Heading}}>
+{{range .Moves}}<p>{{ .FromPath }} is moved to {{ .Path }}</p>{{end}}
+{{end}}{{if .Missing}}<{{$.Heading}}>Missing directories</{{$.Heading}}>
+{{range .Missing}}<p>{{ .Path }} is missing since {{ date .Since }}{{if .Vanished}} and is given up{{if .Bytes}}, it had {{ bytes .Bytes }}{{end}}, {{if .BackupTime.Valid}}the changes until {{ date .BackupTime.Time }} are backed up{{else}}it's not backed up{{end}}{{end}}</p>{{end}}
+{{end}}<{{$.Heading}}>Errors</{{$.Heading}}>
+{{range .Errors}}<p>folder: {{ .Path }} error: {{ .Msg }}</p>{{end}}
+{{end}}`
+
+// Notifier reports the result of a scan
+type Notifier interface {
+	Notify(scanResult *ScanResult) error
+}
+
+// EmailNotifier sends the scan result as email reports.
+// The recipients of the config get the report of all roots,
+// the recipients of a root only get the part of that root.
+type EmailNotifier struct {
+	Config        EmailConfig
+	Policies      []*Policy
+	UsageReportTo []string
+}
+
+func NewEmailNotifier(config *AppConfig) *EmailNotifier {
+	return &EmailNotifier{
+		Config: EmailConfig{
+			ServerName: config.ServerName,
+			Host:       config.SmtpHost,
+			Port:       config.SmtpPort,
+			From:       config.SmtpUser,
+			To:         splitAddresses(config.EmailTo),
+			User:       config.SmtpUser,
+			Password:   config.SmtpPassword,
+		},
+		Policies:      config.Policies(),
+		UsageReportTo: splitAddresses(config.UsageReportTo),
+	}
+}
+
+// SendUsageReport sends the usage report to usage-report-to, or email-to if it's not set
+func (n *EmailNotifier) SendUsageReport(report *UsageReport) error {
+	to := n.UsageReportTo
+	if len(to) == 0 {
+		to = n.Config.To
+	}
+	if len(to) == 0 {
+		return errors.New("no recipients of the usage report")
+	}
+	var buf bytes.Buffer
+	err := report.Write(&buf, "html")
+	if err != nil {
+		return err
+	}
+	e := email.NewEmail()
+	e.From = n.Config.From
+	e.To = to
+	e.Subject = fmt.Sprintf("Usage report %s %s", n.Config.ServerName, report.Time.Format("Mon, 02 Jan 2006"))
+	e.HTML = buf.Bytes()
+	return e.Send(n.Config.addr(), n.Config.auth())
+}
+
+// NotifyError is returned by a Notifier which sent a part of the notices
+type NotifyError struct {
+	Msg    string
+	Unsent map[string]bool // ids of the datasets whose notices didn't reach all the recipients
+}
+
+func (e *NotifyError) Error() string {
+	return e.Msg
+}
+
+// Notify sends one email to every group of recipients which get the same roots.
+// A failed email doesn't stop the others from being sent, the notices in the failed emails are returned in a *NotifyError.
+func (n *EmailNotifier) Notify(scanResult *ScanResult) error {
+	failures := make([]string, 0)
+	unsent := make(map[string]bool)
+	for _, r := range n.reports(scanResult) {
+		err := sendReport(r, scanResult.Time, &n.Config)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", strings.Join(r.To, ","), err))
+			for _, s := range r.Sections {
+				for _, notice := range s.Notices {
+					unsent[notice.ID] = true
+				}
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return &NotifyError{Msg: "failed to send the report to " + strings.Join(failures, "; "), Unsent: unsent}
+	}
+	return nil
+}
+
+type EmailConfig struct {
+	ServerName string
+	Host       string
+	Port       int
+	From       string
+	To         []string // recipients of the report of all roots
+	User       string
+	Password   Secret
+}
+
+// report is the content of one email
+type report struct {
+	To       []string
+	Heading  string // html tag of the headings in a section
+	Sections []*reportSection
+}
+
+// reportSection is the part of the scan result of one root
+type reportSection struct {
+	Title           string
+	Notices         []ArchiveNotice
+	ArchivedFolders []ArchivedFolder
+	Copies          []CopiedDataset
+	Moves           []MovedDataset
+	Missing         []MissingDataset
+	Errors          []ScanError
+}
+
+func (s *reportSection) empty() bool {
+	return len(s.Errors) == 0 && len(s.ArchivedFolders) == 0 && len(s.Notices) == 0 && len(s.Copies) == 0 &&
+		len(s.Moves) == 0 && len(s.Missing) == 0
+}
+
+// split the scan result by root and group the recipients which get the same roots
+func (n *EmailNotifier) reports(scanResult *ScanResult) []*report {
+	roots := make([]string, 0, len(n.Policies)+1)
+	sections := make(map[string]*reportSection)
+	for _, p := range n.Policies {
+		roots = append(roots, p.Root)
+		sections[p.Root] = &reportSection{Title: p.Name}
+	}
+	section := func(root string) *reportSection {
+		s, ok := sections[root]
+		if !ok { // not under any configured root
+			s = &reportSection{Title: "Other"}
+			roots = append(roots, root)
+			sections[root] = s
+		}
+		return s
+	}
+	for _, notice := range scanResult.Notices {
+		s := section(notice.Root)
+		s.Notices = append(s.Notices, notice)
+	}
+	for _, folder := range scanResult.ArchivedFolders {
+		s := section(folder.Root)
+		s.ArchivedFolders = append(s.ArchivedFolders, folder)
+	}
+	for _, copied := range scanResult.Copies {
+		s := section(copied.Root)
+		s.Copies = append(s.Copies, copied)
+	}
+	for _, moved := range scanResult.Moves {
+		s := section(moved.Root)
+		s.Moves = append(s.Moves, moved)
+	}
+	for _, missing := range scanResult.Missing {
+		s := section(missing.Root)
+		s.Missing = append(s.Missing, missing)
+	}
+	for _, scanErr := range scanResult.Errors {
+		s := section(scanErr.Root)
+		s.Errors = append(s.Errors, scanErr)
+	}
+
+	titled := len(sections) > 1
+	if !titled {
+		for _, s := range sections {
+			s.Title = ""
+		}
+	}
+
+	recipients := make([]string, 0)
+	rootsOf := make(map[string][]string)
+	add := func(to string, root string) {
+		if _, ok := rootsOf[to]; !ok {
+			recipients = append(recipients, to)
+		}
+		if !containsString(rootsOf[to], root) {
+			rootsOf[to] = append(rootsOf[to], root)
+		}
+	}
+	for _, to := range n.Config.To {
+		for _, root := range roots {
+			add(to, root)
+		}
+	}
+	for _, p := range n.Policies {
+		for _, to := range p.EmailTo {
+			add(to, p.Root)
+		}
+	}
+
+	heading := "h1"
+	if titled {
+		heading = "h2"
+	}
+	reports := make([]*report, 0)
+	byRoots := make(map[string]*report)
+	for _, to := range recipients {
+		key := strings.Join(rootsOf[to], "\n")
+		if r, ok := byRoots[key]; ok {
+			r.To = append(r.To, to)
+			continue
+		}
+		r := &report{To: []string{to}, Heading: heading}
+		for _, root := range roots { // keep the order of the config
+			if containsString(rootsOf[to], root) && !sections[root].empty() {
+				r.Sections = append(r.Sections, sections[root])
+			}
+		}
+		byRoots[key] = r
+		if len(r.Sections) > 0 { // if nothing to notice, no email
+			reports = append(reports, r)
+		}
+	}
+
+	// the extra recipients of a dataset only get the notices of that dataset,
+	// unless they get the report of its root anyway
+	datasetReports := make(map[string]*report)
+	datasetReport := func(to string, root string) *reportSection {
+		r, ok := datasetReports[to]
+		if !ok {
+			r = &report{To: []string{to}, Heading: heading}
+			datasetReports[to] = r
+			reports = append(reports, r)
+		}
+		title := sections[root].Title
+		for _, s := range r.Sections {
+			if s.Title == title {
+				return s
+			}
+		}
+		s := &reportSection{Title: title}
+		r.Sections = append(r.Sections, s)
+		return s
+	}
+	for _, notice := range scanResult.Notices {
+		for _, to := range notice.NoticeTo {
+			if !containsString(rootsOf[to], notice.Root) {
+				s := datasetReport(to, notice.Root)
+				s.Notices = append(s.Notices, notice)
+			}
+		}
+	}
+	for _, folder := range scanResult.ArchivedFolders {
+		for _, to := range folder.NoticeTo {
+			if !containsString(rootsOf[to], folder.Root) {
+				s := datasetReport(to, folder.Root)
+				s.ArchivedFolders = append(s.ArchivedFolders, folder)
+			}
+		}
+	}
+	return reports
+}
+
+// format a size with decimal units, e.g. 2.3 TB
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, prefix := range "kMGTP" {
+		value /= unit
+		if value < unit || prefix == 'P' {
+			return fmt.Sprintf("%.1f %cB", value, prefix)
+		}
+	}
+	return ""
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sendReport(r *report, scanTime time.Time, c *EmailConfig) error {
+	t, err := template.New("emailbody").Funcs(template.FuncMap{"bytes": formatBytes, "date": formatDate}).Parse(tpl)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	out := bufio.NewWriter(&buf)
+	err = t.Execute(out, r)
+	out.Flush()
+	if err != nil {
+		return err
+	}
+	e := email.NewEmail()
+	e.From = c.From
+	e.To = r.To
+	timeStr := scanTime.Format("Mon, 02 Jan 2006")
+	e.Subject = fmt.Sprintf("Archive report %s %s", c.ServerName, timeStr)
+	e.HTML = buf.Bytes()
+	err = e.Send(c.addr(), c.auth())
+	return err
+}
+
+func (c *EmailConfig) addr() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+// the local email server is used without authentication
+func (c *EmailConfig) auth() smtp.Auth {
+	if c.Host == "localhost" {
+		return nil
+	}
+	if c.Host == "smtp-mail.outlook.com" {
+		return LoginAuth(c.User, c.Password.Value())
+	}
+	return smtp.PlainAuth("", c.User, c.Password.Value(), c.Host)
+}
@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+
+	"rubenlab.org/autoarchive/internal/smtptest"
+)
+
+func TestEmailReportsByRoot(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	config := DefaultConfig()
+	config.SmtpHost = server.Host()
+	config.SmtpPort = server.Port()
+	config.SmtpUser = "archive@example.org"
+	config.EmailTo = "admin@example.org"
+	config.Roots = []RootConfig{
+		{Path: "/storage/krios", Name: "krios", EmailTo: "krios@example.org,pi@example.org"},
+		{Path: "/storage/scratch", Name: "scratch", EmailTo: "pi@example.org"},
+		{Path: "/storage/quiet", EmailTo: "quiet@example.org"},
+	}
+	result := &ScanResult{
+		Notices: []ArchiveNotice{
+			{Root: "/storage/krios", Path: "/storage/krios/ds1", DaysBeforeArchive: 5, NoticeTo: []string{"owner@example.org", "admin@example.org"}},
+		},
+		ArchivedFolders: []ArchivedFolder{{Root: "/storage/scratch", Path: "/storage/scratch/tmp"}},
+		Errors:          []ScanError{{Path: "/old/ds2", Msg: "the dataset is not under any configured root"}},
+	}
+	err = NewEmailNotifier(config).Notify(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := map[string]string{}
+	for _, msg := range server.Messages() {
+		bodies[strings.Join(msg.To, ",")] = msg.Body
+	}
+	if len(bodies) != 4 {
+		t.Fatalf("expected 4 emails, got %v", bodies)
+	}
+	expected := map[string][]string{
+		"admin@example.org": {"<h1>krios</h1>", "/storage/krios/ds1", "<h1>scratch</h1>", "/storage/scratch/tmp", "<h1>Other</h1>", "/old/ds2"},
+		"krios@example.org": {"<h1>krios</h1>", "/storage/krios/ds1"},
+		"pi@example.org":    {"<h1>krios</h1>", "/storage/krios/ds1", "<h1>scratch</h1>", "/storage/scratch/tmp"},
+		"owner@example.org": {"<h1>krios</h1>", "/storage/krios/ds1"},
+	}
+	for to, parts := range expected {
+		body, ok := bodies[to]
+		if !ok {
+			t.Errorf("no email to %s", to)
+			continue
+		}
+		for _, part := range parts {
+			if !strings.Contains(body, part) {
+				t.Errorf("email to %s doesn't contain %s:\n%s", to, part, body)
+			}
+		}
+		if to != "admin@example.org" && strings.Contains(body, "/old/ds2") {
+			t.Errorf("email to %s contains the report of another root:\n%s", to, body)
+		}
+	}
+	for _, to := range []string{"krios@example.org", "owner@example.org"} {
+		if strings.Contains(bodies[to], "scratch") {
+			t.Errorf("email to %s contains the report of scratch:\n%s", to, bodies[to])
+		}
+	}
+}
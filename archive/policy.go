@@ -0,0 +1,380 @@
+package archive
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RootConfig is a storage root with its own policy.
+// Values which are not set (zero or empty) are taken from the top level of the config.
+type RootConfig struct {
+	Path             string   `yaml:"path"`              // root path to scan
+	Name             string   `yaml:"name"`              // name of the root in the report, the path if empty
+	ScanLevel        int      `yaml:"scan-level"`        // If the scan depth reaches ScanLevel, force the directories to be marked as dataset
+	ScanInterval     int      `yaml:"scan-interval"`     // scan interval in days
+	ArchiveInterval  int      `yaml:"archive-interval"`  // archive interval in days
+	NoticeBefore     []int    `yaml:"notice-before"`     // how many days to notice before archive
+	CharacterFolders []string `yaml:"character-folders"` // a folder containing one of these folders is a dataset
+	ArchiveCommand   string   `yaml:"archive-command"`   // archive command, ${path} and ${id} can be used.
+	BackupCommand    string   `yaml:"backup-command"`    // backup command, ${id}, ${dir}, ${file}, ${date} can be used.
+	EmailTo          string   `yaml:"email-to"`          // comma separated, they get the report of this root, in addition to the top level email-to
+	// limits of the overrides in the .datasetinfo files
+	MaxArchiveInterval int      `yaml:"max-archive-interval"` // the longest archive interval a dataset can ask for
+	AllowNoBackup      *bool    `yaml:"allow-no-backup"`      // if a dataset can turn off its backup
+	BackupTargets      []string `yaml:"backup-targets"`       // the backup targets a dataset can choose, the first one is the default
+	// capacity mode: when the file system of the root is used above the high watermark in percent,
+	// the oldest datasets are archived early until the usage would be below the low watermark.
+	CapacityHighWatermark int `yaml:"capacity-high-watermark"` // 0 turns capacity mode off
+	CapacityLowWatermark  int `yaml:"capacity-low-watermark"`
+	CapacityMinAge        int `yaml:"capacity-min-age"`     // days a dataset must be unmodified to be picked
+	CapacityNoticeDays    int `yaml:"capacity-notice-days"` // days between picking a dataset and archiving it
+	// signals which tell that a dataset is in use, in addition to the modify time of the files
+	Activity []string `yaml:"activity"`
+	Watch    *bool    `yaml:"watch"` // follow the changes of the datasets in the watch daemon
+	// a dataset folder inside another dataset: parent (part of the outer dataset), child (a dataset of its own)
+	// or error (reported, the outer dataset is not archived)
+	NestedDatasets string `yaml:"nested-datasets"`
+}
+
+// Policy is the effective configuration of a storage root,
+// or of a dataset if it has overrides in its .datasetinfo file
+type Policy struct {
+	Name                  string
+	Root                  string
+	ScanLevel             int
+	ScanInterval          int
+	ArchiveInterval       int
+	NoticeBefore          []int // from the largest to the smallest
+	CharacterFolders      []string
+	ArchiveCommand        string
+	BackupCommand         string
+	EmailTo               []string // recipients of this root only
+	MaxArchiveInterval    int
+	AllowNoBackup         bool
+	BackupTargets         []string
+	CapacityHighWatermark int
+	CapacityLowWatermark  int
+	CapacityMinAge        int
+	CapacityNoticeDays    int
+	Activity              []string // a dataset can add signals
+	Watch                 bool
+	NestedDatasets        string
+	// set by the overrides of a dataset
+	Hold         string // the reason why the dataset is not archived early in capacity mode
+	NoBackup     bool
+	BackupTarget string   // replaces ${target} in the backup command
+	NoticeTo     []string // extra recipients of the notices of the dataset
+	// where the values come from, by their yaml name: "config", "root" or "dataset"
+	Sources map[string]string
+	// the overrides of the dataset which are not allowed and ignored
+	Problems []string
+}
+
+// RootConfigs returns the configured storage roots,
+// a config with a single root only has the top level root value.
+func (c *AppConfig) RootConfigs() []RootConfig {
+	if len(c.Roots) > 0 {
+		return c.Roots
+	}
+	if c.Root == "" {
+		return nil
+	}
+	return []RootConfig{{Path: c.Root}}
+}
+
+// Policies returns the effective policy of every storage root, in the order of the config
+func (c *AppConfig) Policies() []*Policy {
+	roots := c.RootConfigs()
+	policies := make([]*Policy, 0, len(roots))
+	for _, r := range roots {
+		policies = append(policies, c.policy(&r))
+	}
+	return policies
+}
+
+func (c *AppConfig) policy(r *RootConfig) *Policy {
+	p := &Policy{
+		Name:             r.Name,
+		Root:             filepath.Clean(r.Path),
+		ScanLevel:        r.ScanLevel,
+		ScanInterval:     r.ScanInterval,
+		ArchiveInterval:  r.ArchiveInterval,
+		NoticeBefore:     r.NoticeBefore,
+		CharacterFolders: r.CharacterFolders,
+		ArchiveCommand:   r.ArchiveCommand,
+		BackupCommand:    r.BackupCommand,
+		EmailTo:          splitAddresses(r.EmailTo),
+		Sources:          make(map[string]string),
+	}
+	// inherit reports if the value is taken from the top level
+	inherit := func(name string, unset bool) bool {
+		if unset {
+			p.Sources[name] = "config"
+		} else {
+			p.Sources[name] = "root"
+		}
+		return unset
+	}
+	if p.Name == "" {
+		p.Name = p.Root
+	}
+	if inherit("scan-level", p.ScanLevel == 0) {
+		p.ScanLevel = c.ScanLevel
+	}
+	if inherit("scan-interval", p.ScanInterval == 0) {
+		p.ScanInterval = c.ScanInterval
+	}
+	if inherit("archive-interval", p.ArchiveInterval == 0) {
+		p.ArchiveInterval = c.ArchiveInterval
+	}
+	if inherit("notice-before", len(p.NoticeBefore) == 0) {
+		p.NoticeBefore = c.NoticeBefore
+	}
+	if inherit("character-folders", len(p.CharacterFolders) == 0) {
+		p.CharacterFolders = c.CharacterFolders
+	}
+	if inherit("archive-command", p.ArchiveCommand == "") {
+		p.ArchiveCommand = c.ArchiveCommand
+	}
+	if inherit("backup-command", p.BackupCommand == "") {
+		p.BackupCommand = c.BackupCommand
+	}
+	p.MaxArchiveInterval = r.MaxArchiveInterval
+	if inherit("max-archive-interval", p.MaxArchiveInterval == 0) {
+		p.MaxArchiveInterval = c.MaxArchiveInterval
+	}
+	p.AllowNoBackup = c.AllowNoBackup
+	if !inherit("allow-no-backup", r.AllowNoBackup == nil) {
+		p.AllowNoBackup = *r.AllowNoBackup
+	}
+	p.BackupTargets = r.BackupTargets
+	if inherit("backup-targets", len(p.BackupTargets) == 0) {
+		p.BackupTargets = c.BackupTargets
+	}
+	p.CapacityHighWatermark = r.CapacityHighWatermark
+	if inherit("capacity-high-watermark", p.CapacityHighWatermark == 0) {
+		p.CapacityHighWatermark = c.CapacityHighWatermark
+	}
+	p.CapacityLowWatermark = r.CapacityLowWatermark
+	if inherit("capacity-low-watermark", p.CapacityLowWatermark == 0) {
+		p.CapacityLowWatermark = c.CapacityLowWatermark
+	}
+	p.CapacityMinAge = r.CapacityMinAge
+	if inherit("capacity-min-age", p.CapacityMinAge == 0) {
+		p.CapacityMinAge = c.CapacityMinAge
+	}
+	p.CapacityNoticeDays = r.CapacityNoticeDays
+	if inherit("capacity-notice-days", p.CapacityNoticeDays == 0) {
+		p.CapacityNoticeDays = c.CapacityNoticeDays
+	}
+	p.Activity = r.Activity
+	if inherit("activity", len(p.Activity) == 0) {
+		p.Activity = c.Activity
+	}
+	p.Watch = c.Watch
+	if !inherit("watch", r.Watch == nil) {
+		p.Watch = *r.Watch
+	}
+	p.NestedDatasets = r.NestedDatasets
+	if inherit("nested-datasets", p.NestedDatasets == "") {
+		p.NestedDatasets = c.NestedDatasets
+	}
+	if len(p.BackupTargets) > 0 {
+		p.BackupTarget = p.BackupTargets[0]
+		p.Sources["backup-target"] = p.Sources["backup-targets"]
+	}
+	// don't share the slice with the config
+	noticeBefore := make([]int, len(p.NoticeBefore))
+	copy(noticeBefore, p.NoticeBefore)
+	sort.Sort(sort.Reverse(sort.IntSlice(noticeBefore)))
+	p.NoticeBefore = noticeBefore
+	return p
+}
+
+// DatasetPolicy returns the policy of a dataset, with the overrides of its .datasetinfo file.
+// An override which isn't allowed by the policy is ignored and added to the Problems.
+func (p *Policy) DatasetPolicy(info *Datasetinfo) *Policy {
+	d := *p
+	d.Sources = make(map[string]string, len(p.Sources))
+	for name, source := range p.Sources {
+		d.Sources[name] = source
+	}
+	problems := make([]string, 0)
+	if info.ArchiveInterval != 0 {
+		maxArchiveInterval := p.ArchiveInterval
+		if p.MaxArchiveInterval > maxArchiveInterval {
+			maxArchiveInterval = p.MaxArchiveInterval
+		}
+		if info.ArchiveInterval < 1 {
+			problems = append(problems, fmt.Sprintf("archive-interval is %d, it must be at least 1", info.ArchiveInterval))
+		} else if info.ArchiveInterval > maxArchiveInterval {
+			problems = append(problems, fmt.Sprintf("archive-interval is %d, it can't be longer than %d", info.ArchiveInterval, maxArchiveInterval))
+		} else {
+			d.ArchiveInterval = info.ArchiveInterval
+			d.Sources["archive-interval"] = "dataset"
+		}
+	}
+	if info.NoBackup {
+		if p.AllowNoBackup {
+			d.NoBackup = true
+			d.Sources["no-backup"] = "dataset"
+		} else {
+			problems = append(problems, "no-backup is not allowed")
+		}
+	}
+	if info.BackupTarget != "" {
+		if containsString(p.BackupTargets, info.BackupTarget) {
+			d.BackupTarget = info.BackupTarget
+			d.Sources["backup-target"] = "dataset"
+		} else {
+			problems = append(problems, fmt.Sprintf("backup-target %s is not one of the backup targets [%s]", info.BackupTarget, strings.Join(p.BackupTargets, ", ")))
+		}
+	}
+	if info.NoticeTo != "" {
+		d.NoticeTo = splitAddresses(info.NoticeTo)
+		d.Sources["notice-to"] = "dataset"
+	}
+	if info.Hold != "" {
+		d.Hold = info.Hold
+		d.Sources["hold"] = "dataset"
+	}
+	if len(info.Activity) > 0 { // more signals can only keep the dataset longer
+		if invalid := activityProblems(info.Activity); len(invalid) > 0 {
+			problems = append(problems, invalid...)
+		} else {
+			d.Activity = make([]string, len(p.Activity), len(p.Activity)+len(info.Activity))
+			copy(d.Activity, p.Activity)
+			for _, signal := range info.Activity {
+				if !containsString(d.Activity, signal) {
+					d.Activity = append(d.Activity, signal)
+				}
+			}
+			d.Sources["activity"] = "dataset"
+		}
+	}
+	d.Problems = problems
+	return &d
+}
+
+// split a comma separated list of email addresses
+func splitAddresses(s string) []string {
+	addresses := make([]string, 0)
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses
+}
+
+// find the policy of the root containing the path, nil if the path is not under any root
+func policyOf(policies []*Policy, path string) *Policy {
+	for _, p := range policies {
+		if isUnder(path, p.Root) {
+			return p
+		}
+	}
+	return nil
+}
+
+// return true if path is inside the folder root
+func isUnder(path string, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// validate the policies, the problems are prefixed with the root if the config has several roots
+func (c *AppConfig) policyProblems() []string {
+	problems := make([]string, 0)
+	policies := c.Policies()
+	if len(policies) == 0 {
+		problems = append(problems, "root is empty")
+	}
+	if c.Root != "" && len(c.Roots) > 0 {
+		problems = append(problems, "root and roots can't be used together")
+	}
+	for i, p := range policies {
+		prefix := ""
+		if len(c.Roots) > 0 {
+			prefix = fmt.Sprintf("roots[%d] %s: ", i, p.Root)
+		}
+		add := func(format string, args ...interface{}) {
+			problems = append(problems, prefix+fmt.Sprintf(format, args...))
+		}
+		if c.RootConfigs()[i].Path == "" {
+			add("path is empty")
+		}
+		for _, other := range policies[:i] {
+			if other.Root == p.Root || isUnder(p.Root, other.Root) || isUnder(other.Root, p.Root) {
+				add("overlaps with root %s", other.Root)
+			}
+		}
+		if p.ScanLevel < 1 {
+			add("scan-level is %d, it must be at least 1", p.ScanLevel)
+		}
+		if p.ScanInterval < 0 {
+			add("scan-interval is %d, it can't be negative", p.ScanInterval)
+		}
+		if p.ArchiveInterval < 1 {
+			add("archive-interval is %d, it must be at least 1", p.ArchiveInterval)
+		}
+		seen := map[int]bool{}
+		for _, days := range p.NoticeBefore {
+			if days < 1 {
+				add("notice-before contains %d, notice days must be at least 1", days)
+			} else if days >= p.ArchiveInterval {
+				add("notice-before contains %d, it must be shorter than archive-interval %d", days, p.ArchiveInterval)
+			}
+			if seen[days] {
+				add("notice-before contains %d more than once", days)
+			}
+			seen[days] = true
+		}
+		if len(p.CharacterFolders) == 0 {
+			add("character-folders is empty")
+		}
+		if p.ArchiveCommand == "" {
+			add("archive-command is empty, expired datasets can't be archived")
+		} else if err := checkCommandSyntax(p.ArchiveCommand, archivePlaceholders); err != nil {
+			add("archive-command: %v", err)
+		}
+		if p.BackupCommand != "" {
+			if err := checkCommandSyntax(p.BackupCommand, backupPlaceholders); err != nil {
+				add("backup-command: %v", err)
+			}
+		}
+		if p.CapacityHighWatermark != 0 {
+			if p.CapacityHighWatermark < 1 || p.CapacityHighWatermark > 100 {
+				add("capacity-high-watermark is %d, it must be between 1 and 100", p.CapacityHighWatermark)
+			}
+			if p.CapacityLowWatermark < 0 || p.CapacityLowWatermark >= p.CapacityHighWatermark {
+				add("capacity-low-watermark is %d, it must be lower than capacity-high-watermark %d", p.CapacityLowWatermark, p.CapacityHighWatermark)
+			}
+			if p.CapacityMinAge < 0 {
+				add("capacity-min-age is %d, it can't be negative", p.CapacityMinAge)
+			}
+			if p.CapacityNoticeDays < 1 {
+				add("capacity-notice-days is %d, it must be at least 1", p.CapacityNoticeDays)
+			}
+		}
+		for _, problem := range activityProblems(p.Activity) {
+			add("%s", problem)
+		}
+		if !containsString(NestedModes, p.NestedDatasets) {
+			add("nested-datasets %q is not one of [%s]", p.NestedDatasets, strings.Join(NestedModes, ", "))
+		}
+		if p.MaxArchiveInterval != 0 && p.MaxArchiveInterval < p.ArchiveInterval {
+			add("max-archive-interval is %d, it can't be shorter than archive-interval %d", p.MaxArchiveInterval, p.ArchiveInterval)
+		}
+		if len(splitAddresses(c.EmailTo)) == 0 && len(p.EmailTo) == 0 {
+			add("email-to is empty, reports can't be sent")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPolicies(t *testing.T) {
+	config := DefaultConfig()
+	config.ArchiveCommand = "rm -rf ${path}"
+	config.Roots = []RootConfig{
+		{Path: "/storage/krios/", Name: "krios", NoticeBefore: []int{1, 7}, EmailTo: "krios@example.org, pi@example.org"},
+		{Path: "/storage/scratch", ArchiveInterval: 7, ArchiveCommand: "rm -rf ${path}/*"},
+	}
+	policies := config.Policies()
+	krios, scratch := policies[0], policies[1]
+	if krios.Name != "krios" || krios.Root != "/storage/krios" || krios.ArchiveInterval != 30 || krios.ArchiveCommand != "rm -rf ${path}" {
+		t.Errorf("values are not taken from the top level: %+v", krios)
+	}
+	if fmt.Sprint(krios.NoticeBefore) != "[7 1]" || fmt.Sprint(krios.EmailTo) != "[krios@example.org pi@example.org]" {
+		t.Errorf("notice days or recipients not correct: %+v", krios)
+	}
+	if scratch.Name != "/storage/scratch" || scratch.ArchiveInterval != 7 || scratch.ArchiveCommand != "rm -rf ${path}/*" || len(scratch.EmailTo) != 0 {
+		t.Errorf("values of the root are not used: %+v", scratch)
+	}
+	if p := policyOf(policies, "/storage/krios/user1/ds1"); p != krios {
+		t.Errorf("wrong policy for a krios dataset: %+v", p)
+	}
+	if p := policyOf(policies, "/storage/krios-old/ds1"); p != nil {
+		t.Errorf("a folder next to a root is not under it: %+v", p)
+	}
+}
+
+func TestDatasetPolicy(t *testing.T) {
+	allow := true
+	config := DefaultConfig()
+	config.ArchiveCommand = "rm -rf ${path}"
+	config.Roots = []RootConfig{
+		{Path: "/storage/krios", MaxArchiveInterval: 90, AllowNoBackup: &allow, BackupTargets: []string{"tape", "cloud"}, Activity: []string{"ctime"}},
+		{Path: "/storage/scratch"},
+	}
+	policies := config.Policies()
+	cases := []struct {
+		name     string
+		root     *Policy
+		info     Datasetinfo
+		check    func(p *Policy) bool
+		problems []string
+	}{
+		{"no overrides", policies[0], Datasetinfo{}, func(p *Policy) bool {
+			return p.ArchiveInterval == 30 && p.BackupTarget == "tape" && !p.NoBackup && p.Sources["archive-interval"] == "config"
+		}, nil},
+		{"allowed overrides", policies[0], Datasetinfo{ArchiveInterval: 60, BackupTarget: "cloud", NoBackup: true, NoticeTo: "owner@example.org"}, func(p *Policy) bool {
+			return p.ArchiveInterval == 60 && p.BackupTarget == "cloud" && p.NoBackup && fmt.Sprint(p.NoticeTo) == "[owner@example.org]" &&
+				p.Sources["archive-interval"] == "dataset" && p.Sources["backup-target"] == "dataset" && p.Sources["max-archive-interval"] == "root"
+		}, nil},
+		{"more activity signals", policies[0], Datasetinfo{Activity: []string{"atime", "ctime"}}, func(p *Policy) bool {
+			return fmt.Sprint(p.Activity) == "[ctime atime]" && p.Sources["activity"] == "dataset" && fmt.Sprint(policies[0].Activity) == "[ctime]"
+		}, nil},
+		{"shorter archive interval", policies[1], Datasetinfo{ArchiveInterval: 7}, func(p *Policy) bool { return p.ArchiveInterval == 7 }, nil},
+		{"not allowed overrides", policies[1], Datasetinfo{ArchiveInterval: 60, BackupTarget: "cloud", NoBackup: true, Activity: []string{"touch"}}, func(p *Policy) bool {
+			return p.ArchiveInterval == 30 && p.BackupTarget == "" && !p.NoBackup && p.Sources["archive-interval"] == "config" && len(p.Activity) == 0
+		}, []string{
+			"archive-interval is 60, it can't be longer than 30",
+			"no-backup is not allowed",
+			"backup-target cloud is not one of the backup targets []",
+			"activity touch is not one of [mtime, ctime, atime, keepalive, feed]",
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := c.root.DatasetPolicy(&c.info)
+			if !c.check(p) {
+				t.Errorf("unexpected policy %+v", p)
+			}
+			if strings.Join(p.Problems, "\n") != strings.Join(c.problems, "\n") {
+				t.Errorf("problems:\n%s\nexpected:\n%s", strings.Join(p.Problems, "\n"), strings.Join(c.problems, "\n"))
+			}
+			if c.root.Sources["archive-interval"] != "config" {
+				t.Errorf("the policy of the root is changed")
+			}
+		})
+	}
+}
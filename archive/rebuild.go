@@ -0,0 +1,231 @@
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+)
+
+// RebuildResult is the outcome of Engine.Rebuild
+type RebuildResult struct {
+	Active   int      // records recreated from the .datasetinfo files
+	Archived int      // records recreated from the logs of the archive commands
+	Problems []string // inconsistencies found on the way, sorted
+}
+
+// Rebuild recreates the records of an empty database from the .datasetinfo files under the roots,
+// e.g. after the database file is lost. Nothing is written to the folders: a dataset folder
+// without a .datasetinfo file is reported, it gets a new id by the next run.
+// The modify time of every dataset is found by walking it.
+// If logFolder is not empty, the archived records are recreated from the logs of the succeeded archive commands in it.
+func (e *Engine) Rebuild(logFolder string) (*RebuildResult, error) {
+	if empty, err := storeEmpty(e.Store); err != nil {
+		return nil, err
+	} else if !empty {
+		return nil, errors.New("the database is not empty, it can only be rebuilt into a new database")
+	}
+	r := &rebuild{e: e, byID: make(map[string][]string)}
+	policies := e.Config.Policies()
+	for _, policy := range policies {
+		r.findDatasets(policy, policy.Root, 1)
+	}
+
+	// a copied .datasetinfo, the first path keeps the id and the next run gives the others their own
+	ids := make([]string, 0, len(r.byID))
+	for id, paths := range r.byID {
+		sort.Strings(paths)
+		if len(paths) > 1 {
+			r.problem("%s: the id %s is held by %s too, the next run gives them their own ids", paths[0], id, strings.Join(paths[1:], ", "))
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	cores := e.Config.Cores
+	if cores < 1 {
+		cores = 1
+	}
+	wp := workerpool.New(cores)
+	records := make([]*DatasetRecord, len(ids))
+	for i, id := range ids {
+		i, id := i, id
+		wp.Submit(func() {
+			records[i] = r.recreate(policies, id, r.byID[id][0])
+		})
+	}
+	wp.StopWait()
+	batch := &RecordBatch{}
+	for _, record := range records {
+		batch.Updates = append(batch.Updates, record)
+		if batch.Len() >= discoverBatchSize {
+			if err := e.Store.WriteBatch(batch); err != nil {
+				return nil, errors.Wrap(err, "failed to save the records")
+			}
+			batch = &RecordBatch{}
+		}
+	}
+
+	archived := 0
+	if logFolder != "" {
+		found, err := r.archivedFromLogs(logFolder)
+		if err != nil {
+			return nil, err
+		}
+		batch.Archived = found
+		archived = len(found)
+	}
+	if err := e.Store.WriteBatch(batch); err != nil {
+		return nil, errors.Wrap(err, "failed to save the records")
+	}
+	sort.Strings(r.problems)
+	return &RebuildResult{Active: len(records), Archived: archived, Problems: r.problems}, nil
+}
+
+// rebuild is the state of Engine.Rebuild
+type rebuild struct {
+	e    *Engine
+	byID map[string][]string // paths of the .datasetinfo files by id
+
+	mu       sync.Mutex
+	problems []string
+}
+
+func (r *rebuild) problem(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("rebuild: %s", msg)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.problems = append(r.problems, msg)
+}
+
+// find the .datasetinfo files like ScanFolders, without creating any
+func (r *rebuild) findDatasets(policy *Policy, path string, level int) {
+	files, err := r.e.FS.ReadDir(path)
+	if err != nil {
+		r.problem("%s: %v", path, err)
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || info.Mode()&os.ModeSymlink == os.ModeSymlink {
+			continue
+		}
+		dir := filepath.Join(path, file.Name())
+		if _, err := r.e.FS.Stat(filepath.Join(dir, DatasetFileName)); err == nil {
+			datasetinfo, err := ReadDatasetinfo(r.e.FS, dir)
+			if err == nil && datasetinfo.ID == "" {
+				err = errors.New("the id is empty")
+			}
+			if err != nil {
+				r.problem("%s: %s can't be used, the dataset is added by the next run: %v", dir, DatasetFileName, err)
+				continue
+			}
+			r.byID[datasetinfo.ID] = append(r.byID[datasetinfo.ID], dir)
+			continue
+		}
+		if level >= policy.ScanLevel || r.e.containsCharacterFolder(dir, policy.CharacterFolders) {
+			r.problem("%s: the dataset has no %s, it gets a new id by the next run", dir, DatasetFileName)
+			continue
+		}
+		r.findDatasets(policy, dir, level+1)
+	}
+}
+
+// the record of a dataset, with the modify time found by walking it
+func (r *rebuild) recreate(policies []*Policy, id string, path string) *DatasetRecord {
+	record := &DatasetRecord{ID: id, Path: path}
+	if info, err := ReadDatasetinfo(r.e.FS, path); err == nil {
+		record.BackupTime = info.BackupTime
+		record.CopiedFrom = info.CopiedFrom
+	}
+	policy := r.e.datasetPolicy(policyOf(policies, path), path)
+	activity, stats, _, err := r.e.scanUpdateTime(path, policy, time.Time{})
+	if incomplete, ok := err.(*IncompleteScanError); ok {
+		r.problem("%s: %v", path, err)
+		record.Unreadable = incomplete.Unreadable
+	} else if err != nil { // scanned as a new dataset by the next run
+		r.problem("%s: %v", path, err)
+		return record
+	} else {
+		record.Stats = stats
+	}
+	record.LastModifyTime = sql.NullTime{Time: activity.Time, Valid: true}
+	record.ActivitySignal = activity.Signal
+	record.ScanTime = sql.NullTime{Time: r.e.Clock.Now(), Valid: true}
+	if fi, err := r.e.FS.Stat(path); err == nil {
+		record.Owner = ownerOf(fi)
+	}
+	return record
+}
+
+// the archive commands write their output to <log folder>/<date>/archive_<id>.log,
+// starting with the path of the dataset and ending with the result. The latest log of a succeeded archive
+// of an id which isn't active gives its archived record. A failed archive has a log too, the dataset is still
+// on the disk then or removed later, and the logs of older versions have no result: they are reported.
+func (r *rebuild) archivedFromLogs(logFolder string) ([]*DatasetRecord, error) {
+	days, err := r.e.FS.ReadDir(logFolder)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the log folder")
+	}
+	found := make(map[string]*DatasetRecord)
+	for _, day := range days {
+		date, err := time.ParseInLocation("2006-01-02", day.Name(), time.Local)
+		if !day.IsDir() || err != nil {
+			continue
+		}
+		logs, err := r.e.FS.ReadDir(filepath.Join(logFolder, day.Name()))
+		if err != nil {
+			r.problem("%s: %v", filepath.Join(logFolder, day.Name()), err)
+			continue
+		}
+		for _, l := range logs {
+			name := l.Name()
+			if !strings.HasPrefix(name, "archive_") || !strings.HasSuffix(name, ".log") {
+				continue
+			}
+			id := strings.TrimSuffix(strings.TrimPrefix(name, "archive_"), ".log")
+			if _, active := r.byID[id]; active {
+				continue
+			}
+			logPath := filepath.Join(logFolder, day.Name(), name)
+			data, err := r.e.FS.ReadFile(logPath)
+			if err != nil {
+				r.problem("%s: %v", logPath, err)
+				continue
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			first := strings.TrimSpace(lines[0])
+			path := strings.TrimPrefix(first, "folder path: ")
+			if path == first || path == "" {
+				r.problem("%s: the path of the dataset is not found in the log", logPath)
+				continue
+			}
+			// the log of several runs of a day ends with the result of the last one
+			if strings.TrimSpace(lines[len(lines)-1]) != archiveSucceeded {
+				r.problem("%s: the archive of %s is not recorded as succeeded, its record is not recreated", logPath, path)
+				continue
+			}
+			if previous, ok := found[id]; !ok || date.After(previous.ArchiveTime.Time) {
+				found[id] = &DatasetRecord{ID: id, Path: path, ArchiveTime: sql.NullTime{Time: date, Valid: true}}
+			}
+		}
+	}
+	records := make([]*DatasetRecord, 0, len(found))
+	for _, record := range found {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
@@ -0,0 +1,92 @@
+package archive
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebuild(t *testing.T) {
+	e, sim, clock := newSimulation(t, simTime(0, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	addFrames(t, sim.fsys, ds2, simTime(0, 1), "frames/1.tif", "frames/2.tif")
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.ScanRecords(); err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := e.Store.ListActiveRecords()
+	id1 := ""
+	for _, r := range expected {
+		if r.Path == ds1 {
+			id1 = r.ID
+		}
+	}
+
+	// a copy holding the id of ds1, a dataset without .datasetinfo, and the logs of archive commands
+	ds3, ds4 := "/storage/krios/user1/ds3", "/storage/krios/user1/ds4"
+	addFrames(t, sim.fsys, ds3, simTime(1, 0), "frames/1.tif")
+	info, _ := sim.fsys.ReadFile(filepath.Join(ds1, DatasetFileName))
+	if err := sim.fsys.AddFile(filepath.Join(ds3, DatasetFileName), info, simTime(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	addFrames(t, sim.fsys, ds4, simTime(1, 0), "frames/1.tif")
+	logs := map[string]string{
+		"/logs/2023-03-01/archive_gone.log":        "folder path: /storage/krios/user1/before\n\narchive result: ok\n",
+		"/logs/2023-03-05/archive_gone.log":        "folder path: /storage/krios/user1/gone\nmoved\n\narchive result: ok\n",
+		"/logs/2023-03-05/archive_" + id1 + ".log": "folder path: " + ds1 + "\n\narchive result: failed, exit status 1\n",
+		"/logs/2023-03-05/archive_bad.log":         "no path\n",
+		"/logs/notes/archive_other.log":            "folder path: /storage/other\n",
+		// failed and the folder lost its .datasetinfo later
+		"/logs/2023-03-05/archive_failed.log": "folder path: /storage/krios/user1/failed\n\narchive result: failed, exit status 1\n",
+		// the second run of the day failed
+		"/logs/2023-03-06/archive_again.log": "folder path: /storage/krios/user1/again\n\narchive result: ok\n" +
+			"folder path: /storage/krios/user1/again\n\narchive result: failed, exit status 1\n",
+		// written by an older version
+		"/logs/2023-03-06/archive_old.log": "folder path: /storage/krios/user1/old\nmoved\n",
+	}
+	for name, content := range logs {
+		if err := sim.fsys.AddFile(name, []byte(content), simTime(1, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rebuilt := newSimulationEngine(t, sim, clock)
+	result, err := rebuilt.Rebuild("/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Active != 2 || result.Archived != 1 || len(result.Problems) != 6 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	for i, expected := range []string{
+		"/logs/2023-03-05/archive_bad.log: the path",
+		"/logs/2023-03-05/archive_failed.log: the archive of /storage/krios/user1/failed is not recorded as succeeded",
+		"/logs/2023-03-06/archive_again.log: the archive of /storage/krios/user1/again is not recorded as succeeded",
+		"/logs/2023-03-06/archive_old.log: the archive of /storage/krios/user1/old is not recorded as succeeded",
+		ds1 + ": the id", ds4 + ": the dataset has no",
+	} {
+		if !strings.Contains(result.Problems[i], expected) {
+			t.Errorf("problem %d is %q, expected %q", i, result.Problems[i], expected)
+		}
+	}
+	records, _ := rebuilt.Store.ListActiveRecords()
+	if len(records) != len(expected) {
+		t.Fatalf("%d active records, expected %d", len(records), len(expected))
+	}
+	for i, r := range records {
+		if r.ID != expected[i].ID || r.Path != expected[i].Path || !r.LastModifyTime.Time.Equal(expected[i].LastModifyTime.Time) || r.Stats.Files != expected[i].Stats.Files {
+			t.Errorf("rebuilt record %+v, expected %+v", r, expected[i])
+		}
+	}
+	archived, _ := rebuilt.Store.ListArchivedRecords()
+	if len(archived) != 1 || archived[0].ID != "gone" || archived[0].Path != "/storage/krios/user1/gone" ||
+		!archived[0].ArchiveTime.Time.Equal(time.Date(2023, 3, 5, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("unexpected archived records: %+v", archived)
+	}
+	if _, err := rebuilt.Rebuild(""); err == nil {
+		t.Errorf("a database which is not empty is rebuilt")
+	}
+}
@@ -0,0 +1,314 @@
+package archive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os/user"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// datasets archived within this number of days are listed as archived soon
+const archiveSoonDays = 30
+
+// the age of a dataset is the days since it's last modified
+var ageBuckets = []struct {
+	label   string
+	maxDays int // -1 for no limit
+}{
+	{"0-7 days", 7},
+	{"8-30 days", 30},
+	{"31-90 days", 90},
+	{"over 90 days", -1},
+}
+
+// ReportFormats are the formats UsageReport.Write can write
+var ReportFormats = []string{"text", "csv", "json", "html"}
+
+// UsageReport is the storage used per owner and per group of the dataset folders
+type UsageReport struct {
+	ServerName string
+	Time       time.Time
+	LastMonth  string        // the month of ArchivedLastMonth, e.g. 2026-09
+	Owners     []*UsageEntry // from the largest
+	Groups     []*UsageEntry
+}
+
+// UsageEntry is the usage of one owner or group
+type UsageEntry struct {
+	ID                int // uid or gid, -1 if the owner is not known
+	Name              string
+	Datasets          int   // active datasets
+	Bytes             int64 // size of the active datasets
+	Ages              []AgeCount
+	ArchiveSoon       []ReportDataset // to be archived in the next 30 days
+	ArchivedLastMonth []ReportDataset
+}
+
+type AgeCount struct {
+	Label    string
+	Datasets int
+	Bytes    int64
+}
+
+type ReportDataset struct {
+	Path  string
+	Bytes int64
+	Date  time.Time // when it's archived
+}
+
+// the names of uid and gid, empty if they are not known
+var lookupUserName = func(uid int) string {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+var lookupGroupName = func(gid int) string {
+	g, err := user.LookupGroupId(strconv.Itoa(gid))
+	if err != nil {
+		return ""
+	}
+	return g.Name
+}
+
+func newUsageEntry(id int, lookup func(int) string) *UsageEntry {
+	entry := &UsageEntry{ID: id, Name: "unknown"}
+	if id >= 0 {
+		entry.Name = lookup(id)
+		if entry.Name == "" {
+			entry.Name = strconv.Itoa(id)
+		}
+	}
+	for _, bucket := range ageBuckets {
+		entry.Ages = append(entry.Ages, AgeCount{Label: bucket.label})
+	}
+	return entry
+}
+
+// UsageReport counts the active datasets and the datasets archived last month per owner and per group
+func (e *Engine) UsageReport() (*UsageReport, error) {
+	active, err := e.Store.ListActiveRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading active records")
+	}
+	archived, err := e.Store.ListArchivedRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading archived records")
+	}
+	now := e.Clock.Now()
+	today := startOfDay(now)
+	thisMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+
+	owners := make(map[int]*UsageEntry)
+	groups := make(map[int]*UsageEntry)
+	entries := func(owner *Owner) []*UsageEntry {
+		uid, gid := -1, -1
+		if owner != nil {
+			uid, gid = owner.UID, owner.GID
+		}
+		if owners[uid] == nil {
+			owners[uid] = newUsageEntry(uid, lookupUserName)
+		}
+		if groups[gid] == nil {
+			groups[gid] = newUsageEntry(gid, lookupGroupName)
+		}
+		return []*UsageEntry{owners[uid], groups[gid]}
+	}
+
+	policies := e.Config.Policies()
+	for i := range active {
+		r := &active[i]
+		for _, entry := range entries(r.Owner) {
+			entry.Datasets++
+			entry.Bytes += r.Stats.Bytes
+		}
+		if !r.LastModifyTime.Valid { // not scanned yet
+			continue
+		}
+		unchangeDays := int(today.Sub(startOfDay(r.LastModifyTime.Time)).Hours() / 24)
+		bucket := len(ageBuckets) - 1
+		for j, b := range ageBuckets {
+			if unchangeDays <= b.maxDays {
+				bucket = j
+				break
+			}
+		}
+		policy := policyOf(policies, r.Path)
+		leftDays := archiveSoonDays + 1
+		if policy != nil {
+			leftDays, _ = e.leftDays(e.datasetPolicy(policy, r.Path), r)
+			if leftDays < 0 {
+				leftDays = 0
+			}
+		}
+		for _, entry := range entries(r.Owner) {
+			entry.Ages[bucket].Datasets++
+			entry.Ages[bucket].Bytes += r.Stats.Bytes
+			if leftDays <= archiveSoonDays {
+				entry.ArchiveSoon = append(entry.ArchiveSoon, ReportDataset{Path: r.Path, Bytes: r.Stats.Bytes, Date: today.AddDate(0, 0, leftDays)})
+			}
+		}
+	}
+	for _, r := range archived {
+		if !r.ArchiveTime.Valid || r.ArchiveTime.Time.Before(lastMonth) || !r.ArchiveTime.Time.Before(thisMonth) {
+			continue
+		}
+		for _, entry := range entries(r.Owner) {
+			entry.ArchivedLastMonth = append(entry.ArchivedLastMonth, ReportDataset{Path: r.Path, Bytes: r.Stats.Bytes, Date: r.ArchiveTime.Time})
+		}
+	}
+
+	report := &UsageReport{
+		ServerName: e.Config.ServerName,
+		Time:       now,
+		LastMonth:  lastMonth.Format("2006-01"),
+		Owners:     sortedEntries(owners),
+		Groups:     sortedEntries(groups),
+	}
+	return report, nil
+}
+
+func sortedEntries(m map[int]*UsageEntry) []*UsageEntry {
+	entries := make([]*UsageEntry, 0, len(m))
+	for _, entry := range m {
+		sort.Slice(entry.ArchiveSoon, func(i, j int) bool { return entry.ArchiveSoon[i].Date.Before(entry.ArchiveSoon[j].Date) })
+		sort.Slice(entry.ArchivedLastMonth, func(i, j int) bool {
+			return entry.ArchivedLastMonth[i].Date.Before(entry.ArchivedLastMonth[j].Date)
+		})
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes != entries[j].Bytes {
+			return entries[i].Bytes > entries[j].Bytes
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+func totalBytes(datasets []ReportDataset) int64 {
+	var total int64
+	for _, d := range datasets {
+		total += d.Bytes
+	}
+	return total
+}
+
+// Write writes the report in one of the ReportFormats
+func (r *UsageReport) Write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		return r.writeText(w)
+	case "csv":
+		return r.writeCSV(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "html":
+		return r.writeHTML(w)
+	}
+	return errors.Errorf("unknown report format %s, it must be one of %v", format, ReportFormats)
+}
+
+func (r *UsageReport) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "Usage report %s %s\n", r.ServerName, r.Time.Format("2006-01-02"))
+	for _, part := range []struct {
+		title   string
+		entries []*UsageEntry
+	}{{"Owners", r.Owners}, {"Groups", r.Groups}} {
+		fmt.Fprintf(w, "\n%s\n", part.title)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprint(tw, "NAME\tID\tDATASETS\tSIZE")
+		for _, bucket := range ageBuckets {
+			fmt.Fprintf(tw, "\t%s", bucket.label)
+		}
+		fmt.Fprintf(tw, "\tARCHIVE IN %d DAYS\tARCHIVED %s\n", archiveSoonDays, r.LastMonth)
+		for _, entry := range part.entries {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s", entry.Name, entry.ID, entry.Datasets, formatBytes(entry.Bytes))
+			for _, age := range entry.Ages {
+				fmt.Fprintf(tw, "\t%d", age.Datasets)
+			}
+			fmt.Fprintf(tw, "\t%d (%s)\t%d (%s)\n", len(entry.ArchiveSoon), formatBytes(totalBytes(entry.ArchiveSoon)),
+				len(entry.ArchivedLastMonth), formatBytes(totalBytes(entry.ArchivedLastMonth)))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *UsageReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"kind", "id", "name", "datasets", "bytes"}
+	for _, bucket := range ageBuckets {
+		header = append(header, "age "+bucket.label)
+	}
+	header = append(header, "archive soon", "archive soon bytes", "archived last month", "archived last month bytes")
+	cw.Write(header)
+	for _, part := range []struct {
+		kind    string
+		entries []*UsageEntry
+	}{{"owner", r.Owners}, {"group", r.Groups}} {
+		for _, entry := range part.entries {
+			row := []string{part.kind, strconv.Itoa(entry.ID), entry.Name, strconv.Itoa(entry.Datasets), strconv.FormatInt(entry.Bytes, 10)}
+			for _, age := range entry.Ages {
+				row = append(row, strconv.Itoa(age.Datasets))
+			}
+			row = append(row, strconv.Itoa(len(entry.ArchiveSoon)), strconv.FormatInt(totalBytes(entry.ArchiveSoon), 10),
+				strconv.Itoa(len(entry.ArchivedLastMonth)), strconv.FormatInt(totalBytes(entry.ArchivedLastMonth), 10))
+			cw.Write(row)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+const reportTpl = `
+<h1>Usage report {{ .ServerName }} {{ .Time.Format "2006-01-02" }}</h1>
+{{range $part := .Parts}}
+<h2>{{ $part.Title }}</h2>
+<table>
+<tr><th>Name</th><th>ID</th><th>Datasets</th><th>Size</th>{{range $.AgeLabels}}<th>{{ . }}</th>{{end}}<th>Archive in {{ $.ArchiveSoonDays }} days</th><th>Archived {{ $.LastMonth }}</th></tr>
+{{range $part.Entries}}<tr><td>{{ .Name }}</td><td>{{ .ID }}</td><td>{{ .Datasets }}</td><td>{{ bytes .Bytes }}</td>{{range .Ages}}<td>{{ .Datasets }}</td>{{end}}<td>{{ len .ArchiveSoon }} ({{ bytes (total .ArchiveSoon) }})</td><td>{{ len .ArchivedLastMonth }} ({{ bytes (total .ArchivedLastMonth) }})</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Archive in the next {{ .ArchiveSoonDays }} days</h2>
+{{range .Owners}}{{ $name := .Name }}{{range .ArchiveSoon}}<p>{{ .Date.Format "2006-01-02" }} {{ $name }}: {{ .Path }} ({{ bytes .Bytes }})</p>
+{{end}}{{end}}
+<h2>Archived in {{ .LastMonth }}</h2>
+{{range .Owners}}{{ $name := .Name }}{{range .ArchivedLastMonth}}<p>{{ .Date.Format "2006-01-02" }} {{ $name }}: {{ .Path }} ({{ bytes .Bytes }})</p>
+{{end}}{{end}}`
+
+func (r *UsageReport) writeHTML(w io.Writer) error {
+	t, err := template.New("report").Funcs(template.FuncMap{"bytes": formatBytes, "total": totalBytes}).Parse(reportTpl)
+	if err != nil {
+		return err
+	}
+	type part struct {
+		Title   string
+		Entries []*UsageEntry
+	}
+	labels := make([]string, 0, len(ageBuckets))
+	for _, bucket := range ageBuckets {
+		labels = append(labels, bucket.label)
+	}
+	return t.Execute(w, struct {
+		*UsageReport
+		Parts           []part
+		AgeLabels       []string
+		ArchiveSoonDays int
+	}{r, []part{{"Owners", r.Owners}, {"Groups", r.Groups}}, labels, archiveSoonDays})
+}
@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageReport(t *testing.T) {
+	userName, groupName := lookupUserName, lookupGroupName
+	defer func() { lookupUserName, lookupGroupName = userName, groupName }()
+	lookupUserName = func(uid int) string { return map[int]string{1000: "alice"}[uid] }
+	lookupGroupName = func(gid int) string { return map[int]string{100: "cryo"}[gid] }
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	clock := NewManualClock(time.Date(2026, 10, 1, 2, 0, 0, 0, time.Local))
+	config := DefaultConfig()
+	config.Root = "/storage"
+	e := NewEngine(config, store)
+	e.Clock = clock
+	e.FS = NewMemFS(clock)
+	modified := func(days int) sql.NullTime {
+		return sql.NullTime{Time: clock.Now().AddDate(0, 0, -days), Valid: true}
+	}
+	alice := &Owner{UID: 1000, GID: 100}
+	bob := &Owner{UID: 1001, GID: 100}
+	records := []DatasetRecord{
+		{ID: "a1", Path: "/storage/a1", Owner: alice, LastModifyTime: modified(2), Stats: DatasetStats{Bytes: 1000}},
+		{ID: "a2", Path: "/storage/a2", Owner: alice, LastModifyTime: modified(20), Stats: DatasetStats{Bytes: 2000}},
+		{ID: "b1", Path: "/storage/b1", Owner: bob, LastModifyTime: modified(100), Stats: DatasetStats{Bytes: 500}},
+		{ID: "u1", Path: "/storage/u1"},
+	}
+	for i := range records {
+		if err := store.AddRecord(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archived := []DatasetRecord{
+		{ID: "a0", Path: "/storage/a0", Owner: alice, ArchiveTime: sql.NullTime{Time: time.Date(2026, 9, 15, 2, 0, 0, 0, time.Local), Valid: true}, Stats: DatasetStats{Bytes: 300}},
+		{ID: "a-old", Path: "/storage/a-old", Owner: alice, ArchiveTime: sql.NullTime{Time: time.Date(2026, 8, 15, 2, 0, 0, 0, time.Local), Valid: true}},
+	}
+	for i := range archived {
+		if err := store.SaveArchiveRecord(&archived[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := e.UsageReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Owners) != 3 || len(report.Groups) != 2 || report.LastMonth != "2026-09" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	a := report.Owners[0]
+	if a.Name != "alice" || a.Datasets != 2 || a.Bytes != 3000 || a.Ages[0].Datasets != 1 || a.Ages[1].Datasets != 1 {
+		t.Errorf("unexpected usage of alice %+v", a)
+	}
+	// a2 is archived in 10 days, a1 in 28 days
+	if len(a.ArchiveSoon) != 2 || a.ArchiveSoon[0].Path != "/storage/a2" || len(a.ArchivedLastMonth) != 1 || a.ArchivedLastMonth[0].Path != "/storage/a0" {
+		t.Errorf("unexpected archived datasets of alice %+v", a)
+	}
+	if b := report.Owners[1]; b.Name != "1001" || b.Ages[3].Datasets != 1 || len(b.ArchiveSoon) != 1 || !b.ArchiveSoon[0].Date.Equal(startOfDay(clock.Now())) {
+		t.Errorf("unexpected usage of bob %+v", b)
+	}
+	if u := report.Owners[2]; u.Name != "unknown" || u.ID != -1 || u.Datasets != 1 {
+		t.Errorf("unexpected usage of unknown owner %+v", u)
+	}
+	if g := report.Groups[0]; g.Name != "cryo" || g.Datasets != 3 || g.Bytes != 3500 {
+		t.Errorf("unexpected usage of the group %+v", g)
+	}
+	for _, format := range ReportFormats {
+		var buf strings.Builder
+		if err := report.Write(&buf, format); err != nil {
+			t.Errorf("format %s: %v", format, err)
+		}
+		if !strings.Contains(buf.String(), "alice") || !strings.Contains(buf.String(), "cryo") {
+			t.Errorf("format %s misses the names:\n%s", format, buf.String())
+		}
+	}
+	if err := report.Write(ioutil.Discard, "xml"); err == nil {
+		t.Errorf("unknown format is accepted")
+	}
+}
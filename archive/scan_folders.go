@@ -0,0 +1,166 @@
+package archive
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+)
+
+// number of records written in one transaction by the discovery
+const discoverBatchSize = 1000
+
+// ScanFolders finds the dataset folders under the root of the policy.
+// The folders are read by Cores workers, the records found are written at the end
+// in a few transactions, sorted by path. A folder which can't be read doesn't stop
+// the discovery of the others, the errors are returned sorted by path.
+func (e *Engine) ScanFolders(policy *Policy) error {
+	cores := e.Config.Cores
+	if cores < 1 {
+		cores = 1
+	}
+	d := &discovery{
+		e:      e,
+		policy: policy,
+		wp:     workerpool.New(cores),
+		errors: make(map[string]error),
+	}
+	d.visit(policy.Root, 1)
+	d.wg.Wait()
+	d.wp.StopWait()
+
+	sort.Slice(d.records, func(i, j int) bool { return d.records[i].Path < d.records[j].Path })
+	d.splitCopies()
+	for start := 0; start < len(d.records); start += discoverBatchSize {
+		end := start + discoverBatchSize
+		if end > len(d.records) {
+			end = len(d.records)
+		}
+		if err := e.Store.WriteBatch(&RecordBatch{Updates: d.records[start:end]}); err != nil {
+			return errors.Wrap(err, "failed to save the datasets found")
+		}
+	}
+	if len(d.errors) > 0 {
+		paths := make([]string, 0, len(d.errors))
+		for path := range d.errors {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		failures := make([]string, 0, len(paths))
+		for _, path := range paths {
+			failures = append(failures, fmt.Sprintf("%s: %v", path, d.errors[path]))
+		}
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// discovery is the state of ScanFolders shared by the workers
+type discovery struct {
+	e      *Engine
+	policy *Policy
+	wp     *workerpool.WorkerPool
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	records []*DatasetRecord // to add or update
+	errors  map[string]error // by path
+}
+
+// read the folder in a worker
+func (d *discovery) visit(path string, level int) {
+	d.wg.Add(1)
+	d.wp.Submit(func() {
+		defer d.wg.Done()
+		d.scanFolder(path, level)
+	})
+}
+
+func (d *discovery) addRecord(record *DatasetRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records = append(d.records, record)
+}
+
+func (d *discovery) addError(path string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errors[path] = err
+}
+
+// several folders found with the same id are copies of each other, none of them is the recorded path.
+// The first one keeps the id, the others get their own.
+func (d *discovery) splitCopies() {
+	seen := make(map[string]bool)
+	for i, record := range d.records {
+		if !seen[record.ID] {
+			seen[record.ID] = true
+			continue
+		}
+		info, err := ReadDatasetinfo(d.e.FS, record.Path)
+		if err == nil {
+			d.records[i], err = d.e.copyDataset(record.Path, info)
+		}
+		if err != nil {
+			d.errors[record.Path] = err
+			d.records[i] = nil
+		}
+	}
+	records := d.records[:0]
+	for _, record := range d.records {
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	d.records = records
+}
+
+func (d *discovery) scanFolder(rootPath string, currentLevel int) {
+	files, err := d.e.FS.ReadDir(rootPath)
+	if err != nil {
+		d.addError(rootPath, err)
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			d.addError(filepath.Join(rootPath, file.Name()), err)
+			continue
+		}
+		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+			// skip symlink folder
+			continue
+		}
+		path := filepath.Join(rootPath, file.Name())
+		isDataset, record, err := d.e.findDataset(d.policy, path)
+		if err != nil {
+			d.addError(path, err)
+			continue
+		}
+		if record != nil {
+			d.addRecord(record)
+		}
+		if isDataset {
+			continue
+		}
+		if currentLevel >= d.policy.ScanLevel {
+			record, err := d.e.newDataset(path)
+			if err != nil {
+				log.Printf("error add dataset, error: %v", err)
+				continue
+			}
+			d.addRecord(record)
+			continue
+		}
+		d.visit(path, currentLevel+1)
+	}
+}
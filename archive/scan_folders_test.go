@@ -0,0 +1,36 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestScanFolders(t *testing.T) {
+	e, sim, _ := newSimulation(t, simTime(0, 2))
+	sim.unreadable = map[string][2]int{"/storage/group03": {0, 1}}
+	for i := 0; i < 40; i++ {
+		addFrames(t, sim.fsys, fmt.Sprintf("/storage/group%02d/user/ds%d", i%8, i), simTime(0, 0), "frames/1.tif")
+	}
+	// a copied dataset, the copy gets its own id
+	sim.fsys.AddFile("/storage/group00/user/a/"+DatasetFileName, []byte("id: copied\n"), simTime(0, 0))
+	sim.fsys.AddFile("/storage/group00/user/b/"+DatasetFileName, []byte("id: copied\n"), simTime(0, 0))
+	e.Config.Cores = 8
+
+	err := e.Discover()
+	if err == nil || !strings.Contains(err.Error(), "/storage/group03: open /storage/group03: permission denied") {
+		t.Errorf("the unreadable folder is not reported: %v", err)
+	}
+	records, _ := e.Store.ListActiveRecords()
+	if len(records) != 37 {
+		t.Errorf("%d records found, expected 37", len(records))
+	}
+	copied, _ := e.Store.GetRecord("copied")
+	if copied == nil || copied.Path != "/storage/group00/user/a" {
+		t.Errorf("unexpected record of the copied dataset %+v", copied)
+	}
+	copies, _ := e.Store.GetRecordsByPath("/storage/group00/user/b")
+	if len(copies) != 1 || copies[0].ID == "copied" || copies[0].CopiedFrom != "copied" {
+		t.Errorf("unexpected record of the copy %+v", copies)
+	}
+}
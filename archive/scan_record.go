@@ -0,0 +1,489 @@
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+)
+
+// number of record changes written in one transaction by a scan
+const scanBatchSize = 100
+
+type ScanResult struct {
+	Time            time.Time // when the scan started
+	Errors          []ScanError
+	Notices         []ArchiveNotice
+	ArchivedFolders []ArchivedFolder
+	Copies          []CopiedDataset
+	Moves           []MovedDataset
+	Missing         []MissingDataset
+}
+
+type ScanError struct {
+	Root string // root of the dataset, empty if it's not under any root
+	ID   string
+	Path string
+	Msg  string
+}
+
+func (e *ScanError) Error() string {
+	return e.Msg
+}
+
+type ArchiveNotice struct {
+	Root              string
+	ID                string
+	Path              string
+	DaysBeforeArchive int
+	Bytes             int64    // size of the dataset
+	NoticeTo          []string // extra recipients asked for by the dataset
+	Capacity          bool     // archived early because the storage is almost full
+	NoticeDay         int      // the notice-before day reached, saved to the record once the notice is sent
+}
+
+// CopiedDataset is a dataset folder found to be the copy of another one, it got its own id
+type CopiedDataset struct {
+	Root     string
+	ID       string
+	Path     string
+	FromID   string
+	FromPath string // the path of the dataset it's copied from, empty if it's not active any more
+}
+
+// MovedDataset is a dataset found at a new path
+type MovedDataset struct {
+	Root     string
+	ID       string
+	Path     string
+	FromPath string
+}
+
+// MissingDataset is a dataset not found at its path, its record is moved to the vanished records after missing-days
+type MissingDataset struct {
+	Root       string
+	ID         string
+	Path       string
+	Since      time.Time
+	Vanished   bool         // the record is moved to the vanished records by this scan
+	Bytes      int64        // the size found by the last scan
+	BackupTime sql.NullTime // the files modified until then are backed up
+}
+
+type ArchivedFolder struct {
+	Root     string
+	ID       string
+	Path     string
+	Bytes    int64
+	NoticeTo []string
+}
+
+type ScanResultModifier struct {
+	Error          *ScanError
+	Notice         *ArchiveNotice
+	ArchivedFolder *ArchivedFolder
+	Copy           *CopiedDataset
+	Move           *MovedDataset
+	Missing        *MissingDataset
+	Record         *DatasetRecord // to save
+	Vanished       *DatasetRecord // to move to the vanished records
+}
+
+func (m *ScanResultModifier) modify(result *ScanResult) {
+	if m.Error != nil {
+		result.Errors = append(result.Errors, *m.Error)
+	} else if m.Notice != nil {
+		result.Notices = append(result.Notices, *m.Notice)
+	} else if m.ArchivedFolder != nil {
+		result.ArchivedFolders = append(result.ArchivedFolders, *m.ArchivedFolder)
+	} else if m.Copy != nil {
+		result.Copies = append(result.Copies, *m.Copy)
+	} else if m.Move != nil {
+		result.Moves = append(result.Moves, *m.Move)
+	} else if m.Missing != nil {
+		result.Missing = append(result.Missing, *m.Missing)
+	}
+}
+
+func (e *Engine) ScanRecords() (*ScanResult, error) {
+	records, err := e.Store.ListActiveRecords()
+	if err != nil {
+		return nil, err
+	}
+	scanResult := ScanResult{
+		Time:            e.Clock.Now(),
+		Errors:          make([]ScanError, 0, 10),
+		Notices:         make([]ArchiveNotice, 0, 10),
+		ArchivedFolders: make([]ArchivedFolder, 0, 10),
+	}
+	policies := e.Config.Policies()
+	conflicts, err := e.Store.PathConflicts()
+	if err != nil {
+		return nil, err
+	}
+	conflictPaths := make([]string, 0, len(conflicts))
+	for path := range conflicts {
+		conflictPaths = append(conflictPaths, path)
+	}
+	sort.Strings(conflictPaths)
+	for _, path := range conflictPaths {
+		ids := conflicts[path]
+		root := ""
+		if policy := policyOf(policies, path); policy != nil {
+			root = policy.Root
+		}
+		scanResult.Errors = append(scanResult.Errors, ScanError{Root: root, Path: path, Msg: pathConflictError(path, ids).Error()})
+	}
+	records, err = e.checkNestedRecords(policies, records, &scanResult)
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan ScanResultModifier)
+	finishChan := make(chan int)
+	// the single writer of the records, the changes are written in batches
+	go func(result *ScanResult, c *chan ScanResultModifier, finishChan *chan int) {
+		batch := &RecordBatch{}
+		write := func() {
+			if batch.Len() == 0 {
+				return
+			}
+			if err := e.Store.WriteBatch(batch); err != nil {
+				log.Printf("failed to save %d records, error: %v", batch.Len(), err)
+				result.Errors = append(result.Errors, ScanError{Msg: fmt.Sprintf("failed to save %d records: %v", batch.Len(), err)})
+			}
+			batch = &RecordBatch{}
+		}
+		for v := range *c {
+			v.modify(result)
+			if v.Record != nil {
+				batch.Updates = append(batch.Updates, v.Record)
+			} else if v.Vanished != nil {
+				batch.Vanished = append(batch.Vanished, v.Vanished)
+			}
+			if batch.Len() >= scanBatchSize {
+				write()
+			}
+		}
+		write()
+		close(*finishChan)
+	}(&scanResult, &c, &finishChan)
+	var feed ActivityFeed
+	if e.Config.ActivityFeed != "" {
+		// without the feed, datasets only used by the feed may look old enough to be archived
+		feed, err = ReadActivityFeed(e.Config.ActivityFeed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	wp := workerpool.New(e.Config.Cores)
+	for _, record := range records {
+		rf := record
+		wp.Submit(func() {
+			e.scanRecord(policies, feed, rf, &c)
+		})
+	}
+	wp.StopWait()
+	close(c)
+	// wait for the finish signal
+	<-finishChan
+	return &scanResult, nil
+}
+
+func (e *Engine) scanRecord(policies []*Policy, feed ActivityFeed, record DatasetRecord, c *chan ScanResultModifier) {
+	id := record.ID
+	path := record.Path
+	policy := policyOf(policies, path)
+	if policy == nil {
+		// the root is removed from the config, leave the dataset alone
+		log.Printf("dataset %s, %s is not under any root", id, path)
+		addErrResult("", id, path, errors.New("the dataset is not under any configured root"), c)
+		return
+	}
+	root := policy.Root
+	fi, err := e.FS.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			e.missing(root, &record, c)
+		} else {
+			log.Printf("failed to open directory, error: %v", err)
+			addErrResult(root, id, path, err, c)
+		}
+		return
+	}
+	if !fi.IsDir() {
+		e.missing(root, &record, c)
+		return
+	}
+	if record.MissingSince.Valid {
+		log.Printf("missing dataset %s is back at %s", id, path)
+		record.MissingSince = sql.NullTime{}
+	}
+	if move := record.movedSinceScan(); move != nil {
+		*c <- ScanResultModifier{Move: &MovedDataset{Root: root, ID: id, Path: path, FromPath: move.Path}}
+	}
+	if record.CopiedFrom != "" && !record.ScanTime.Valid { // reported by its first scan
+		copied := CopiedDataset{Root: root, ID: id, Path: path, FromID: record.CopiedFrom}
+		if from, err := e.Store.GetRecord(record.CopiedFrom); err == nil && from != nil {
+			copied.FromPath = from.Path
+		}
+		*c <- ScanResultModifier{Copy: &copied}
+	}
+	policy = e.datasetPolicy(policy, path)
+	if !e.isShouldScan(policy, &record) {
+		log.Printf("skip scanning record: %s, %s", record.ID, record.Path)
+		return
+	} else {
+		log.Printf("start scanning record: %s, %s", record.ID, record.Path)
+	}
+	for _, problem := range policy.Problems { // the overrides are ignored, tell the admin
+		addErrResult(root, id, path, errors.New(DatasetFileName+": "+problem), c)
+	}
+	// a watched dataset which isn't changed since its last scan is neither walked nor backed up,
+	// it's still walked before it's archived
+	unchanged := false
+	if leftDays, _ := e.leftDays(policy, &record); leftDays > 0 && !record.Dirty && e.Watcher.covers(&record) {
+		unchanged = true
+	}
+	var activity Activity
+	var stats DatasetStats
+	var complete bool
+	if unchanged {
+		activity = Activity{Time: record.LastModifyTime.Time, Signal: record.ActivitySignal}
+	} else {
+		activity, stats, complete, err = e.scanUpdateTime(path, policy, e.earlyStopTime(policy, &record))
+	}
+	record.Unreadable = 0
+	if incomplete, ok := err.(*IncompleteScanError); ok {
+		// the time of the readable part is only a lower bound, the dataset may look older than it is.
+		// Notices are still sent, but it's not archived until it's scanned completely.
+		log.Printf("incomplete scan of %s, error: %v", path, err)
+		addErrResult(root, id, path, errors.Wrap(err, "the dataset is not archived"), c)
+		record.Unreadable = incomplete.Unreadable
+	} else if err != nil {
+		log.Printf("failed to scan update time, error: %v", err)
+		addErrResult(root, id, path, err, c)
+		return
+	}
+	if containsString(policy.Activity, SignalFeed) {
+		activity.observe(feed[path], SignalFeed)
+	}
+	lastUpdateTime := activity.Time
+	if record.LastModifyTime.Valid && lastUpdateTime.After(record.LastModifyTime.Time) {
+		// the folder is modified again, the notices already sent are not valid any more
+		record.NoticedLeftDays = 0
+		record.CapacityDeadline = sql.NullTime{}
+	}
+	if complete { // else keep the stats of the last full walk
+		record.Stats = stats
+		e.handleNested(policy, &record, c)
+	}
+	record.Owner = ownerOf(fi)
+	if record.Unreadable == 0 || !record.LastModifyTime.Valid || lastUpdateTime.After(record.LastModifyTime.Time) {
+		record.LastModifyTime = sql.NullTime{
+			Time:  lastUpdateTime,
+			Valid: true,
+		}
+		record.ActivitySignal = activity.Signal
+	}
+	record.ScanTime = sql.NullTime{
+		Time:  e.Clock.Now(),
+		Valid: true,
+	}
+	e.afterScan(policy, &record, unchanged, c)
+	log.Printf("finish scanning record: %s, %s", record.ID, record.Path)
+}
+
+// the policy of the dataset with the overrides of its .datasetinfo file
+func (e *Engine) datasetPolicy(policy *Policy, path string) *Policy {
+	info, err := ReadDatasetinfo(e.FS, path)
+	if err != nil {
+		log.Printf("failed to read the overrides of %s, error: %v", path, err)
+		return policy
+	}
+	return policy.DatasetPolicy(info)
+}
+
+// a dataset not found at its path is kept for missing-days, the discovery may find it at a new path.
+// Then the record is moved to the vanished records, the admin may restore the dataset from its backup.
+func (e *Engine) missing(root string, record *DatasetRecord, c *chan ScanResultModifier) {
+	now := e.Clock.Now()
+	if !record.MissingSince.Valid {
+		log.Printf("dataset %s is missing at %s", record.ID, record.Path)
+		record.MissingSince = sql.NullTime{Time: now, Valid: true}
+		saveRecord(record, c)
+	}
+	missing := MissingDataset{
+		Root:       root,
+		ID:         record.ID,
+		Path:       record.Path,
+		Since:      record.MissingSince.Time,
+		Bytes:      record.Stats.Bytes,
+		BackupTime: record.BackupTime,
+	}
+	days := int(startOfDay(now).Sub(startOfDay(record.MissingSince.Time)).Hours() / 24)
+	if days < e.Config.MissingDays {
+		*c <- ScanResultModifier{Missing: &missing}
+		return
+	}
+	log.Printf("dataset %s is missing at %s for %d days, it's vanished", record.ID, record.Path, days)
+	missing.Vanished = true
+	vanished := *record
+	vanished.VanishTime = sql.NullTime{Time: now, Valid: true}
+	*c <- ScanResultModifier{Missing: &missing, Vanished: &vanished}
+}
+
+// save the record by the writer of the scan
+func saveRecord(record *DatasetRecord, c *chan ScanResultModifier) {
+	saved := *record
+	*c <- ScanResultModifier{Record: &saved}
+}
+
+func addErrResult(root string, id string, path string, err error, c *chan ScanResultModifier) {
+	scanErr := ScanError{
+		Root: root,
+		ID:   id,
+		Path: path,
+		Msg:  err.Error(),
+	}
+	*c <- ScanResultModifier{Error: &scanErr}
+}
+
+// if a directory is never scanned, or
+// if a directory is found again after it's moved or missing, or
+// if a directory is not scanned for ScanInterval days, or
+// if a directory should be archived today, or
+// if a notice should be sent today, this folder should be scan, and return true
+func (e *Engine) isShouldScan(policy *Policy, record *DatasetRecord) bool {
+	scanTime := record.ScanTime
+	if !scanTime.Valid || record.MissingSince.Valid || record.movedSinceScan() != nil {
+		return true
+	}
+	today := startOfDay(e.Clock.Now())
+	scanDate := startOfDay(scanTime.Time)
+	unscanDays := int(today.Sub(scanDate).Hours() / 24)
+	if unscanDays >= policy.ScanInterval {
+		return true
+	}
+	if record.LastModifyTime.Valid {
+		leftDays, _ := e.leftDays(policy, record)
+		if leftDays <= 0 { // if folder need to be archived today, rescan to check if there're new changes
+			return true
+		}
+		if dueNotice(policy, record.NoticedLeftDays, leftDays) > 0 { // When a notice should be triggered, rescan to check if there're new changes
+			return true
+		}
+	}
+	return false
+}
+
+// return the days left before the dataset is archived,
+// and true if it's archived early in capacity mode.
+func (e *Engine) leftDays(policy *Policy, record *DatasetRecord) (int, bool) {
+	today := startOfDay(e.Clock.Now())
+	lastModifyDate := startOfDay(record.LastModifyTime.Time)
+	unchangeDays := int(today.Sub(lastModifyDate).Hours() / 24)
+	leftDays := policy.ArchiveInterval - unchangeDays
+	if record.CapacityDeadline.Valid {
+		capacityLeftDays := int(startOfDay(record.CapacityDeadline.Time).Sub(today).Hours() / 24)
+		if capacityLeftDays < leftDays {
+			return capacityLeftDays, true
+		}
+	}
+	return leftDays, false
+}
+
+// return the smallest notice day which is reached but not sent yet, 0 if no notice is due.
+// If several notices are reached at once, e.g. for a folder found late, only the last one is sent.
+func dueNotice(policy *Policy, noticedLeftDays int, leftDays int) int {
+	due := 0
+	for _, noticeLeftDays := range policy.NoticeBefore {
+		if noticedLeftDays > 0 && noticedLeftDays <= noticeLeftDays { // This notice is already sent, skip it.
+			continue
+		}
+		if leftDays <= noticeLeftDays {
+			due = noticeLeftDays
+		}
+	}
+	return due
+}
+
+// after scan and update lastModify time,
+// send notice or do archive. The backup is skipped if the watcher knows the dataset is unchanged.
+func (e *Engine) afterScan(policy *Policy, record *DatasetRecord, unchanged bool, c *chan ScanResultModifier) {
+	id := record.ID
+	path := record.Path
+	root := policy.Root
+	leftDays, capacity := e.leftDays(policy, record)
+
+	// Archive the directory and move the record
+	if leftDays <= 0 && record.Unreadable > 0 { // the incomplete scan is already reported
+		log.Printf("skip archiving %s, %d entries can't be read", path, record.Unreadable)
+		saveRecord(record, c)
+		return
+	} else if nested := keptNested(policy, record); leftDays <= 0 && nested != "" {
+		log.Printf("skip archiving %s, it contains the datasets %s", path, nested)
+		addErrResult(root, id, path, errors.Errorf("the dataset is not archived, it contains the datasets %s", nested), c)
+		saveRecord(record, c)
+		return
+	} else if leftDays <= 0 {
+		err := e.Archiver.Archive(policy, path, id)
+		if err != nil {
+			log.Printf("failed to archive, error: %v", err)
+			addErrResult(root, id, path, err, c)
+			saveRecord(record, c)
+			return
+		}
+		record.ArchiveTime = sql.NullTime{
+			Time:  e.Clock.Now(),
+			Valid: true,
+		}
+		err = e.Store.SaveArchiveRecord(record)
+		if err != nil {
+			log.Printf("failed to save archive record, error: %v", err)
+			addErrResult(root, id, path, errors.Wrap(err, "failed to save archived record"), c)
+			return
+		}
+		archivedFolder := ArchivedFolder{
+			Root:     root,
+			ID:       id,
+			Path:     path,
+			Bytes:    record.Stats.Bytes,
+			NoticeTo: policy.NoticeTo,
+		}
+		*c <- ScanResultModifier{ArchivedFolder: &archivedFolder}
+		return
+	} else if record.Unreadable == 0 && !unchanged { // make incremental backups
+		err := e.doBackup(policy, record)
+		if err != nil {
+			log.Printf("failed to do backup, error: %v", err)
+			addErrResult(root, id, path, err, c)
+			saveRecord(record, c)
+			return
+		}
+		record.Dirty = false
+	}
+
+	// check if notice should send, add it to the result object.
+	// The record is marked by Engine.Notify once the notice is sent.
+	if noticeLeftDays := dueNotice(policy, record.NoticedLeftDays, leftDays); noticeLeftDays > 0 {
+		notice := ArchiveNotice{
+			Root:              root,
+			ID:                id,
+			Path:              path,
+			DaysBeforeArchive: leftDays,
+			Bytes:             record.Stats.Bytes,
+			NoticeTo:          policy.NoticeTo,
+			Capacity:          capacity,
+			NoticeDay:         noticeLeftDays,
+		}
+		*c <- ScanResultModifier{Notice: &notice}
+	}
+
+	// save the scan time and the modify time
+	saveRecord(record, c)
+}
@@ -0,0 +1,161 @@
+package archive
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMovedAndMissingDatasets(t *testing.T) {
+	e, sim, clock := newSimulation(t, simTime(0, 2))
+	ds2 := filepath.Join(filepath.Dir(ds1), "ds2")
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	addFrames(t, sim.fsys, ds2, simTime(0, 0), "frames/1.tif")
+	e.Config.MissingDays = 3
+	run := func(day int) *ScanResult {
+		clock.Set(simTime(day, 2))
+		if err := e.Discover(); err != nil {
+			t.Fatal(err)
+		}
+		result, err := e.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	run(0)
+	info1, _ := ReadDatasetinfo(e.FS, ds1)
+	info2, _ := ReadDatasetinfo(e.FS, ds2)
+	record2, _ := e.Store.GetRecord(info2.ID)
+	if !record2.BackupTime.Valid {
+		t.Errorf("the backup time is not recorded %+v", record2)
+	}
+
+	// ds1 is renamed, ds2 is removed
+	moved := filepath.Join(filepath.Dir(ds1), "renamed")
+	data, _ := sim.fsys.ReadFile(filepath.Join(ds1, DatasetFileName))
+	sim.fsys.RemoveAll(ds1)
+	sim.fsys.AddFile(filepath.Join(moved, DatasetFileName), data, simTime(1, 0))
+	sim.fsys.RemoveAll(ds2)
+	result := run(1)
+	expectedMoves := []MovedDataset{{Root: "/storage", ID: info1.ID, Path: moved, FromPath: ds1}}
+	if !reflect.DeepEqual(result.Moves, expectedMoves) {
+		t.Errorf("unexpected moves %+v", result.Moves)
+	}
+	record, _ := e.Store.GetRecord(info1.ID)
+	if len(record.PathHistory) != 1 || record.PathHistory[0].Path != ds1 || !record.PathHistory[0].Until.Equal(simTime(1, 2)) {
+		t.Errorf("unexpected path history %+v", record.PathHistory)
+	}
+	expectedMissing := []MissingDataset{{Root: "/storage", ID: info2.ID, Path: ds2, Since: simTime(1, 2),
+		Bytes: record2.Stats.Bytes, BackupTime: record2.BackupTime}}
+	if !reflect.DeepEqual(result.Missing, expectedMissing) {
+		t.Errorf("unexpected missing datasets %+v", result.Missing)
+	}
+
+	result = run(2)
+	if len(result.Moves) != 0 || len(result.Missing) != 1 {
+		t.Errorf("unexpected moves %+v and missing datasets %+v", result.Moves, result.Missing)
+	}
+	result = run(4)
+	expectedMissing[0].Vanished = true
+	if !reflect.DeepEqual(result.Missing, expectedMissing) {
+		t.Errorf("unexpected missing datasets %+v", result.Missing)
+	}
+	if record, _ := e.Store.GetRecord(info2.ID); record != nil {
+		t.Errorf("the record of the missing dataset is still active %+v", record)
+	}
+	vanished, _ := e.Store.ListVanishedRecords()
+	if len(vanished) != 1 || vanished[0].ID != info2.ID || !vanished[0].VanishTime.Time.Equal(simTime(4, 2)) {
+		t.Errorf("unexpected vanished records %+v", vanished)
+	}
+
+	// a missing dataset found again at a new path is moved
+	sim.fsys.RemoveAll(moved)
+	result = run(5)
+	if len(result.Missing) != 1 || result.Missing[0].ID != info1.ID {
+		t.Errorf("the vanished dataset is reported again %+v", result.Missing)
+	}
+	sim.fsys.AddFile(filepath.Join(ds2, DatasetFileName), data, simTime(6, 0))
+	result = run(6)
+	record, _ = e.Store.GetRecord(info1.ID)
+	if len(result.Moves) != 1 || record.Path != ds2 || record.MissingSince.Valid || len(record.PathHistory) != 2 {
+		t.Errorf("unexpected moves %+v, record %+v", result.Moves, record)
+	}
+}
+
+func TestNoticesResetOnModification(t *testing.T) {
+	e, sim, clock := newSimulation(t, simTime(0, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	run := func(day int) *ScanResult {
+		clock.Set(simTime(day, 2))
+		if err := e.Discover(); err != nil {
+			t.Fatal(err)
+		}
+		result, err := e.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Notify(result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	run(0)
+	if result := run(21); len(result.Notices) != 1 {
+		t.Fatalf("expected the notice of 10 days, got %+v", result.Notices)
+	}
+	if r, _ := e.Store.ListActiveRecords(); r[0].NoticedLeftDays != 10 {
+		t.Fatalf("the notice is not marked sent: %+v", r[0])
+	}
+
+	// the modified dataset gets all its notices again
+	addFrames(t, sim.fsys, ds1, simTime(24, 1), "frames/2.tif")
+	if result := run(25); len(result.Notices) != 0 {
+		t.Errorf("unexpected notices of the modified dataset %+v", result.Notices)
+	}
+	if r, _ := e.Store.ListActiveRecords(); r[0].NoticedLeftDays != 0 {
+		t.Errorf("the sent notices are kept after the modification: %+v", r[0])
+	}
+	if result := run(45); len(result.Notices) != 1 || result.Notices[0].NoticeDay != 10 {
+		t.Errorf("expected the notice of 10 days again, got %+v", result.Notices)
+	}
+}
+
+func TestDueNotice(t *testing.T) {
+	policy := &Policy{NoticeBefore: []int{10, 5, 1}}
+	cases := []struct {
+		noticed, left, due int
+	}{
+		{0, 12, 0},
+		{0, 10, 10},
+		{0, 7, 10},
+		{10, 7, 0},
+		{10, 5, 5},
+		{0, 3, 5}, // found late, only the most urgent notice is sent
+		{5, 3, 0},
+		{5, 1, 1},
+		{1, 1, 0},
+	}
+	for _, c := range cases {
+		if due := dueNotice(policy, c.noticed, c.left); due != c.due {
+			t.Errorf("dueNotice(noticed %d, left %d) = %d, expected %d", c.noticed, c.left, due, c.due)
+		}
+	}
+
+	// the notice tells the real days left, not the notice day
+	e, sim, _ := newSimulation(t, simTime(27, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	if err := sim.fsys.AddFile(filepath.Join(ds1, DatasetFileName), []byte("id: late\n"), simTime(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := e.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Notices) != 1 || result.Notices[0].DaysBeforeArchive != 3 || result.Notices[0].NoticeDay != 5 {
+		t.Errorf("expected a notice of 3 days left for the notice day 5, got %+v", result.Notices)
+	}
+}
@@ -0,0 +1,260 @@
+package archive
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// number of the largest files kept in DatasetStats
+const largestFilesCount = 5
+
+// number of the errors kept in IncompleteScanError
+const scanErrorsCount = 3
+
+// IncompleteScanError is returned when some files or folders of a dataset can't be read.
+// The dataset can be modified in the unreadable part, so it looks older than it may be.
+type IncompleteScanError struct {
+	Unreadable int      // number of the entries which can't be read
+	Errors     []string // the first errors
+}
+
+func (e *IncompleteScanError) Error() string {
+	return fmt.Sprintf("scan incomplete, %d entries can't be read: %s", e.Unreadable, strings.Join(e.Errors, "; "))
+}
+
+// DatasetStats is counted while a dataset is scanned
+type DatasetStats struct {
+	Time         time.Time // when they are counted, zero if never
+	Bytes        int64     // total size of the files
+	Allocated    int64     // bytes allocated on the disk, can be less than Bytes for sparse or compressed files
+	Files        int
+	Dirs         int        // folders inside the dataset
+	LargestFiles []FileSize // from the largest
+	Nested       []string   // the datasets found inside the dataset, relative to its folder
+}
+
+type FileSize struct {
+	Path string // relative to the dataset folder
+	Size int64
+}
+
+func (s *DatasetStats) addFile(relPath string, size int64) {
+	i := len(s.LargestFiles)
+	for i > 0 && s.LargestFiles[i-1].Size < size {
+		i--
+	}
+	if i >= largestFilesCount {
+		return
+	}
+	s.LargestFiles = append(s.LargestFiles, FileSize{})
+	copy(s.LargestFiles[i+1:], s.LargestFiles[i:])
+	s.LargestFiles[i] = FileSize{Path: relPath, Size: size}
+	if len(s.LargestFiles) > largestFilesCount {
+		s.LargestFiles = s.LargestFiles[:largestFilesCount]
+	}
+}
+
+// return the time after which a modified file means that nothing is due for the dataset:
+// no notice, no archive and no pick by capacity mode, and that it's modified since the last scan.
+// The scan can stop at such a file. Zero if the whole dataset must be walked.
+func (e *Engine) earlyStopTime(policy *Policy, record *DatasetRecord) time.Time {
+	if record.Stats.Time.IsZero() { // never fully walked, count the stats first
+		return time.Time{}
+	}
+	window := policy.ArchiveInterval
+	if len(policy.NoticeBefore) > 0 {
+		window -= policy.NoticeBefore[0]
+	}
+	if policy.CapacityHighWatermark != 0 && policy.CapacityMinAge < window {
+		window = policy.CapacityMinAge
+	}
+	if window < 1 {
+		return time.Time{}
+	}
+	// modified on one of the last window days
+	threshold := startOfDay(e.Clock.Now()).AddDate(0, 0, 1-window).Add(-time.Nanosecond)
+	if record.LastModifyTime.Valid && record.LastModifyTime.Time.After(threshold) {
+		threshold = record.LastModifyTime.Time
+	}
+	return threshold
+}
+
+// return the latest activity of the files in the folder by the signals of the policy, and their stats.
+// The folders inside which are datasets themselves are left out with the child mode of nested-datasets.
+// If stopAfter is not zero, the walk stops at the first file used after it,
+// then the time is only a lower bound, the stats are not counted and complete is false.
+// If some entries can't be read, the activity of the readable entries is returned with an *IncompleteScanError.
+func (e *Engine) scanUpdateTime(path string, policy *Policy, stopAfter time.Time) (activity Activity, stats DatasetStats, complete bool, err error) {
+	workers := e.Config.WalkWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	w := &modTimeWalker{
+		fsys:             e.FS,
+		root:             path,
+		signals:          policy.Activity,
+		characterFolders: policy.CharacterFolders,
+		skipNested:       policy.NestedDatasets != NestedParent,
+		stopAfter:        stopAfter,
+		sem:              make(chan struct{}, workers-1), // the calling goroutine is a worker too
+		stop:             make(chan struct{}),
+	}
+	info, err := e.FS.Lstat(path)
+	if err != nil {
+		return Activity{}, DatasetStats{}, false, err
+	}
+	w.observe(path, info)
+	w.wg.Add(1)
+	w.walkDir(path)
+	w.wg.Wait()
+	if w.unreadable > 0 {
+		return w.latest, DatasetStats{}, false, &IncompleteScanError{Unreadable: w.unreadable, Errors: w.errors}
+	}
+	if w.stopped() {
+		return w.latest, DatasetStats{}, false, nil
+	}
+	w.stats.Time = e.Clock.Now()
+	sort.Strings(w.stats.Nested)
+	return w.latest, w.stats, true, nil
+}
+
+// modTimeWalker walks the folders of a dataset in parallel, with at most cap(sem)+1 goroutines,
+// and finds the latest activity by the signals
+type modTimeWalker struct {
+	fsys    FileSystem
+	root    string
+	signals []string
+	// folders inside which are datasets themselves, skipped if skipNested is set
+	characterFolders []string
+	skipNested       bool
+	stopAfter        time.Time
+	sem              chan struct{}
+	wg               sync.WaitGroup
+	stop             chan struct{} // closed when a file used after stopAfter is found
+	stopOnce         sync.Once
+
+	mu         sync.Mutex
+	latest     Activity
+	stats      DatasetStats
+	unreadable int
+	errors     []string
+}
+
+func (w *modTimeWalker) stopped() bool {
+	select {
+	case <-w.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *modTimeWalker) observe(path string, info fs.FileInfo) {
+	if info.Name() == DatasetFileName {
+		return
+	}
+	keepalive := filepath.Dir(path) == w.root && info.Name() == KeepaliveFileName
+	if keepalive && !containsString(w.signals, SignalKeepalive) {
+		return
+	}
+	var activity Activity
+	if keepalive {
+		activity.observe(info.ModTime(), SignalKeepalive)
+	} else {
+		activity.observe(info.ModTime(), SignalMtime)
+		ctime, atime := fileTimes(info)
+		if containsString(w.signals, SignalCtime) {
+			activity.observe(ctime, SignalCtime)
+		}
+		// the folders are read by the scan itself, only the access time of the files counts
+		if containsString(w.signals, SignalAtime) && info.Mode().IsRegular() {
+			activity.observe(atime, SignalAtime)
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.latest.observe(activity.Time, activity.Signal)
+	if info.Mode().IsRegular() {
+		w.stats.Files++
+		w.stats.Bytes += info.Size()
+		w.stats.Allocated += allocatedSize(info)
+		relPath, _ := filepath.Rel(w.root, path)
+		w.stats.addFile(relPath, info.Size())
+	} else if info.IsDir() && path != w.root {
+		w.stats.Dirs++
+	}
+	if !w.stopAfter.IsZero() && activity.Time.After(w.stopAfter) {
+		w.stopOnce.Do(func() { close(w.stop) })
+	}
+}
+
+func (w *modTimeWalker) addError(path string, err error) {
+	log.Printf("error when Wals through folder %s, the error is: %v", path, err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.unreadable++
+	if len(w.errors) < scanErrorsCount {
+		w.errors = append(w.errors, err.Error())
+	}
+}
+
+func (w *modTimeWalker) walkDir(path string) {
+	defer w.wg.Done()
+	if w.stopped() {
+		return
+	}
+	entries, err := w.fsys.ReadDir(path)
+	if err != nil {
+		w.addError(path, err)
+		return
+	}
+	if path != w.root && isNestedDataset(entries, w.characterFolders) {
+		relPath, _ := filepath.Rel(w.root, path)
+		w.mu.Lock()
+		w.stats.Nested = append(w.stats.Nested, relPath)
+		w.mu.Unlock()
+		if w.skipNested {
+			return
+		}
+	}
+	for _, entry := range entries {
+		if w.stopped() {
+			return
+		}
+		// the type comes with the folder listing, only the modify time needs a lstat
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) { // removed while walking
+				continue
+			}
+			w.addError(path, err)
+			continue
+		}
+		name := filepath.Join(path, entry.Name())
+		w.observe(name, info)
+		if entry.IsDir() { // symbolic links are not followed
+			w.spawn(name)
+		}
+	}
+}
+
+// walk the folder in a new goroutine, or in this one if all the workers are busy
+func (w *modTimeWalker) spawn(path string) {
+	w.wg.Add(1)
+	select {
+	case w.sem <- struct{}{}:
+		go func() {
+			defer func() { <-w.sem }()
+			w.walkDir(path)
+		}()
+	default:
+		w.walkDir(path)
+	}
+}
@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDatasetStats(t *testing.T) {
+	stats := DatasetStats{}
+	for i, size := range []int64{3, 9, 1, 7, 5, 8, 2} {
+		stats.addFile(fmt.Sprintf("f%d", i), size)
+	}
+	if fmt.Sprint(stats.LargestFiles) != "[{f1 9} {f5 8} {f3 7} {f4 5} {f0 3}]" {
+		t.Errorf("unexpected largest files %v", stats.LargestFiles)
+	}
+	for n, expected := range map[int64]string{0: "0 B", 999: "999 B", 1500: "1.5 kB", 2300000000000: "2.3 TB", 5e18: "5000.0 PB"} {
+		if s := formatBytes(n); s != expected {
+			t.Errorf("formatBytes(%d) is %s, expected %s", n, s, expected)
+		}
+	}
+}
+
+func TestScanUpdateTime(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 10, 1, 2, 0, 0, 0, time.Local))
+	fsys := NewMemFS(clock)
+	day := func(d int) time.Time { return clock.Now().AddDate(0, 0, d) }
+	fsys.AddFile("/storage/ds/"+DatasetFileName, []byte("id: ds"), day(-30))
+	for i := 0; i < 50; i++ {
+		fsys.AddFile(fmt.Sprintf("/storage/ds/frames/%02d/%d.tif", i%7, i), make([]byte, i), day(-30))
+	}
+	fsys.AddFile("/storage/ds/frames/03/new.tif", []byte("new"), day(-2))
+	config := DefaultConfig()
+	config.WalkWorkers = 3
+	e := &Engine{Config: config, FS: fsys, Clock: clock}
+
+	latest, stats, complete, err := e.scanUpdateTime("/storage/ds", &Policy{}, time.Time{})
+	if err != nil || !complete || !latest.Time.Equal(day(-2)) || latest.Signal != SignalMtime {
+		t.Fatalf("full walk: %v %v %v", latest, complete, err)
+	}
+	if stats.Files != 51 || stats.Dirs != 8 || stats.Bytes != 1228 || stats.LargestFiles[0].Path != "frames/00/49.tif" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	latest, _, complete, err = e.scanUpdateTime("/storage/ds", &Policy{}, day(-10))
+	if err != nil || complete || !latest.Time.After(day(-10)) {
+		t.Errorf("the walk doesn't stop early: %v %v %v", latest, complete, err)
+	}
+
+	p := &Policy{ArchiveInterval: 30, NoticeBefore: []int{10, 5, 1}}
+	record := &DatasetRecord{Stats: stats, LastModifyTime: sql.NullTime{Time: day(-2), Valid: true}}
+	if threshold := e.earlyStopTime(p, record); !threshold.Equal(day(-2)) {
+		t.Errorf("a file modified after the last scan must be found, got %v", threshold)
+	}
+	record.LastModifyTime.Time = day(-25)
+	if threshold := e.earlyStopTime(p, record); !threshold.Equal(startOfDay(day(-19)).Add(-time.Nanosecond)) {
+		t.Errorf("a file modified in the last 20 days must be found, got %v", threshold)
+	}
+	p.CapacityHighWatermark, p.CapacityMinAge = 90, 7
+	if threshold := e.earlyStopTime(p, record); !threshold.Equal(startOfDay(day(-6)).Add(-time.Nanosecond)) {
+		t.Errorf("a file modified in the last 7 days must be found in capacity mode, got %v", threshold)
+	}
+	if threshold := e.earlyStopTime(p, &DatasetRecord{}); !threshold.IsZero() {
+		t.Errorf("a dataset without stats must be fully walked, got %v", threshold)
+	}
+}
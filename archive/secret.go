@@ -0,0 +1,256 @@
+package archive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "******"
+
+// Secret is a string which is never printed, logged or marshalled,
+// use Value to get the real content.
+type Secret string
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+// the environment variable of systemd pointing to the credentials of the service
+const credentialsDirectoryEnv = "CREDENTIALS_DIRECTORY"
+
+// name of the systemd credential used as smtp password, if no password is configured
+const smtpPasswordCredential = "smtp-password"
+
+// ${NAME}, ${NAME:-default} or the escaped $${...}
+var envPattern = regexp.MustCompile(`\$(\$?)\{([^}]*)\}`)
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expand the environment variables in the string values of the config.
+// The placeholders of the commands, like ${path}, are kept for the commands.
+func interpolateConfig(config *AppConfig, lookupEnv func(string) (string, bool)) []string {
+	problems := make([]string, 0)
+	interpolateValue(reflect.ValueOf(config).Elem(), "", lookupEnv, &problems)
+	return problems
+}
+
+// expand the environment variables in the values of the yaml file which aren't text in the config,
+// e.g. smtp-port: ${SMTP_PORT}, they can't be parsed into the config before. The text values are
+// expanded by interpolateConfig. It returns the yaml to parse, data itself if nothing is expanded.
+func interpolateYAML(data []byte, lookupEnv func(string) (string, bool)) ([]byte, []string) {
+	problems := make([]string, 0)
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return data, problems // reported when the config is parsed
+	}
+	changed := false
+	interpolateNode(tree, reflect.TypeOf(AppConfig{}), "", lookupEnv, &problems, &changed)
+	if !changed {
+		return data, problems
+	}
+	expanded, err := yaml.Marshal(tree)
+	if err != nil {
+		return data, append(problems, fmt.Sprintf("the environment variables can't be expanded: %v", err))
+	}
+	return expanded, problems
+}
+
+// the node of the yaml tree is parsed into a value of type t
+func interpolateNode(node interface{}, t reflect.Type, name string, lookupEnv func(string) (string, bool), problems *[]string, changed *bool) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		if t.Kind() != reflect.Struct {
+			return n
+		}
+		for i, item := range n {
+			key, _ := item.Key.(string)
+			field, ok := fieldByYAMLName(t, key)
+			if !ok { // unknown keys are reported when the config is parsed
+				continue
+			}
+			fieldName := key
+			if name != "" {
+				fieldName = name + "." + key
+			}
+			n[i].Value = interpolateNode(item.Value, field.Type, fieldName, lookupEnv, problems, changed)
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return n
+		}
+		for i := range n {
+			n[i] = interpolateNode(n[i], t.Elem(), fmt.Sprintf("%s[%d]", name, i), lookupEnv, problems, changed)
+		}
+	case string:
+		if t.Kind() == reflect.String {
+			return n
+		}
+		expanded := interpolateString(n, name, lookupEnv, problems)
+		if expanded == n {
+			return n
+		}
+		// the value is parsed as if it's written in the file, e.g. 587 is a number
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(expanded), &value); err != nil {
+			return expanded
+		}
+		*changed = true
+		return value
+	}
+	return node
+}
+
+func fieldByYAMLName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath == "" && yamlName(field) == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func interpolateValue(v reflect.Value, name string, lookupEnv func(string) (string, bool), problems *[]string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(interpolateString(v.String(), name, lookupEnv, problems))
+	case reflect.Ptr:
+		if !v.IsNil() {
+			interpolateValue(v.Elem(), name, lookupEnv, problems)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			interpolateValue(v.Index(i), fmt.Sprintf("%s[%d]", name, i), lookupEnv, problems)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" { // unexported
+				continue
+			}
+			fieldName := yamlName(field)
+			if fieldName == "-" {
+				continue
+			}
+			if name != "" {
+				fieldName = name + "." + fieldName
+			}
+			interpolateValue(v.Field(i), fieldName, lookupEnv, problems)
+		}
+	}
+}
+
+// the key of a struct field in the yaml file
+func yamlName(field reflect.StructField) string {
+	tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if tag != "" {
+		return tag
+	}
+	return strings.ToLower(field.Name)
+}
+
+func interpolateString(s string, name string, lookupEnv func(string) (string, bool), problems *[]string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return envPattern.ReplaceAllStringFunc(s, func(match string) string {
+		groups := envPattern.FindStringSubmatch(match)
+		if groups[1] == "$" { // escaped
+			return match[1:]
+		}
+		expr := groups[2]
+		envName, defaultValue, hasDefault := expr, "", false
+		if i := strings.Index(expr, ":-"); i >= 0 {
+			envName, defaultValue, hasDefault = expr[:i], expr[i+2:], true
+		}
+		if isCommandPlaceholder(envName) || !envNamePattern.MatchString(envName) {
+			return match
+		}
+		value, ok := lookupEnv(envName)
+		if !ok || (value == "" && hasDefault) {
+			if hasDefault {
+				return defaultValue
+			}
+			*problems = append(*problems, fmt.Sprintf("%s: environment variable %s is not set", name, envName))
+			return match
+		}
+		return value
+	})
+}
+
+func isCommandPlaceholder(name string) bool {
+	for _, placeholders := range [][]string{archivePlaceholders, backupPlaceholders} {
+		for _, p := range placeholders {
+			if p == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fill the smtp password from smtp-password-file,
+// or from the systemd credential "smtp-password" when no password is configured.
+// A relative smtp-password-file is looked up in the systemd credentials directory first.
+func loadSecrets(config *AppConfig, lookupEnv func(string) (string, bool)) []string {
+	problems := make([]string, 0)
+	credentialsDir, _ := lookupEnv(credentialsDirectoryEnv)
+	passwordFile := config.SmtpPasswordFile
+	if passwordFile != "" {
+		if config.SmtpPassword != "" {
+			problems = append(problems, "smtp-password and smtp-password-file are both set, only one can be used")
+			return problems
+		}
+		if !filepath.IsAbs(passwordFile) && credentialsDir != "" {
+			credential := filepath.Join(credentialsDir, passwordFile)
+			if _, err := os.Stat(credential); err == nil {
+				passwordFile = credential
+			}
+		}
+	} else if config.SmtpPassword == "" && credentialsDir != "" {
+		credential := filepath.Join(credentialsDir, smtpPasswordCredential)
+		if _, err := os.Stat(credential); err == nil {
+			passwordFile = credential
+		}
+	}
+	if passwordFile == "" {
+		return problems
+	}
+	data, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		// the error contains the path only, never the content
+		problems = append(problems, fmt.Sprintf("smtp-password-file: %v", err))
+		return problems
+	}
+	config.SmtpPassword = Secret(strings.TrimRight(string(data), "\r\n"))
+	return problems
+}
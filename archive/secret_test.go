@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestInterpolateConfig(t *testing.T) {
+	env := map[string]string{"STORAGE": "/storage/krios", "ADMIN": "admin@example.org", "EMPTY": ""}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	config := DefaultConfig()
+	config.Root = "${STORAGE}"
+	config.EmailTo = "${ADMIN}"
+	config.ServerName = "${EMPTY:-storage server}"
+	config.ArchiveCommand = `sh -c "mv ${path} ${STORAGE}/archived/${id} && echo $${HOME}"`
+	config.SmtpUser = "${MISSING}"
+	problems := interpolateConfig(config, lookupEnv)
+	if config.Root != "/storage/krios" || config.EmailTo != "admin@example.org" || config.ServerName != "storage server" {
+		t.Errorf("environment variables are not expanded: %+v", config)
+	}
+	if config.ArchiveCommand != `sh -c "mv ${path} /storage/krios/archived/${id} && echo ${HOME}"` {
+		t.Errorf("unexpected archive command %s", config.ArchiveCommand)
+	}
+	if err := checkCommandSyntax(config.ArchiveCommand, archivePlaceholders); err != nil {
+		t.Errorf("the escaped shell variable is reported: %v", err)
+	}
+	if len(problems) != 1 || problems[0] != "smtp-user: environment variable MISSING is not set" {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
+
+func TestInterpolateNumbers(t *testing.T) {
+	t.Setenv("TEST_SMTP_PORT", "587")
+	t.Setenv("TEST_NOTICE", "7")
+	t.Setenv("TEST_WATCH", "true")
+	t.Setenv("TEST_ROOT", "/storage")
+	file := filepath.Join(t.TempDir(), "config.yml")
+	content := `root: ${TEST_ROOT}
+email-to: admin@example.org
+archive-command: "rm -rf ${path}"
+backup-command: "tar --files-from=${file} --file=${id}/${date}.tar"
+smtp-port: ${TEST_SMTP_PORT}
+scan-interval: ${TEST_SCAN_INTERVAL:-5}
+watch: ${TEST_WATCH}
+notice-before:
+  - ${TEST_NOTICE}
+  - 1
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SmtpPort != 587 || config.ScanInterval != 5 || !config.Watch || !reflect.DeepEqual(config.NoticeBefore, []int{7, 1}) {
+		t.Errorf("environment variables are not expanded in the numbers: %+v", config)
+	}
+	if config.Root != "/storage" || config.ArchiveCommand != "rm -rf ${path}" {
+		t.Errorf("unexpected text values %q, %q", config.Root, config.ArchiveCommand)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("smtp-port: ${TEST_MISSING_PORT}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(file)
+	if validationErr, ok := err.(*ValidationError); !ok || validationErr.Problems[0] != "smtp-port: environment variable TEST_MISSING_PORT is not set" {
+		t.Errorf("the missing variable is not reported: %v", err)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "password"), []byte("from-file\n"), FileModeCreate); err != nil {
+		t.Fatal(err)
+	}
+	credentials := filepath.Join(dir, "credentials")
+	if err := os.Mkdir(credentials, FolderModeCreate); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(credentials, "smtp-password"), []byte("from-systemd"), FileModeCreate); err != nil {
+		t.Fatal(err)
+	}
+	noEnv := func(string) (string, bool) { return "", false }
+	systemdEnv := func(name string) (string, bool) {
+		return credentials, name == credentialsDirectoryEnv
+	}
+	cases := []struct {
+		name      string
+		password  Secret
+		file      string
+		lookupEnv func(string) (string, bool)
+		expected  Secret
+		problems  int
+	}{
+		{"inline password", "inline", "", systemdEnv, "inline", 0},
+		{"password file", "", filepath.Join(dir, "password"), noEnv, "from-file", 0},
+		{"systemd credential", "", "", systemdEnv, "from-systemd", 0},
+		{"relative file in credentials directory", "", "smtp-password", systemdEnv, "from-systemd", 0},
+		{"missing file", "", filepath.Join(dir, "missing"), noEnv, "", 1},
+		{"password and file", "inline", filepath.Join(dir, "password"), noEnv, "inline", 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.SmtpPassword = c.password
+			config.SmtpPasswordFile = c.file
+			problems := loadSecrets(config, c.lookupEnv)
+			if config.SmtpPassword != c.expected || len(problems) != c.problems {
+				t.Errorf("password %q problems %v, expected %q with %d problems", config.SmtpPassword.Value(), problems, c.expected.Value(), c.problems)
+			}
+		})
+	}
+}
+
+func TestSecretIsHidden(t *testing.T) {
+	config := DefaultConfig()
+	config.SmtpPassword = "top-secret"
+	jsonData, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlData, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := []string{
+		fmt.Sprintf("%v", config),
+		fmt.Sprintf("%+v", config),
+		fmt.Sprintf("%#v", config),
+		fmt.Sprintf("%s", config.SmtpPassword),
+		string(jsonData),
+		string(yamlData),
+	}
+	for _, output := range outputs {
+		if strings.Contains(output, "top-secret") {
+			t.Errorf("secret is printed: %s", output)
+		}
+	}
+	if config.SmtpPassword.Value() != "top-secret" {
+		t.Errorf("secret value is lost")
+	}
+}
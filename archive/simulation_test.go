@@ -0,0 +1,408 @@
+package archive
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the simulation runs the engine once a day at 02:00,
+// events of a day happen at 01:00, before the run
+var simStart = time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+const simDays = 60
+
+func simTime(day int, hour int) time.Time {
+	return simStart.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+}
+
+// simulation records what the engine does every day
+type simulation struct {
+	mu     sync.Mutex
+	day    int
+	events []string
+	fsys   *MemFS
+	// the archive of a path fails until the day
+	failArchiveUntil map[string]int
+	// the emails can't be sent on these days
+	failNotifyDays map[int]bool
+	// the folder can't be read from the first day until the second one
+	unreadable map[string][2]int
+}
+
+// simFS is the MemFS of a simulation with unreadable folders
+type simFS struct {
+	*MemFS
+	sim *simulation
+}
+
+func (f *simFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if days, ok := f.sim.unreadable[name]; ok && f.sim.day >= days[0] && f.sim.day < days[1] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MemFS.ReadDir(name)
+}
+
+func (s *simulation) record(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, fmt.Sprintf("day %02d: ", s.day)+fmt.Sprintf(format, args...))
+}
+
+func (s *simulation) Archive(policy *Policy, path string, id string) error {
+	if s.day < s.failArchiveUntil[path] {
+		return fmt.Errorf("archive of %s failed", path)
+	}
+	s.record("archive %s", path)
+	return s.fsys.RemoveAll(path)
+}
+
+func (s *simulation) Enabled(policy *Policy) bool {
+	return true
+}
+
+func (s *simulation) Backup(policy *Policy, id string, dir string, relativePaths []string, date time.Time) error {
+	s.record("backup %s %s", dir, strings.Join(relativePaths, ","))
+	return nil
+}
+
+func (s *simulation) Notify(scanResult *ScanResult) error {
+	if s.failNotifyDays[s.day] {
+		s.record("notify failed")
+		return fmt.Errorf("smtp server is down")
+	}
+	for _, n := range scanResult.Notices {
+		if n.Capacity {
+			s.record("notice %s %d capacity", n.Path, n.DaysBeforeArchive)
+		} else {
+			s.record("notice %s %d", n.Path, n.DaysBeforeArchive)
+		}
+	}
+	for _, e := range scanResult.Errors {
+		s.record("error %s: %s", e.Path, e.Msg)
+	}
+	return nil
+}
+
+func newSimulationEngine(t *testing.T, sim *simulation, clock Clock) *Engine {
+	config := DefaultConfig()
+	config.Root = "/storage"
+	config.ScanLevel = 3
+	config.ScanInterval = 3
+	config.ArchiveInterval = 30
+	config.NoticeBefore = []int{10, 5, 1}
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	e := NewEngine(config, store)
+	e.Clock = clock
+	e.FS = &simFS{MemFS: sim.fsys, sim: sim}
+	e.Archiver = sim
+	e.Backupper = sim
+	e.Notifier = sim
+	return e
+}
+
+// newSimulation creates an empty in-memory storage at the time now, and an engine working on it
+func newSimulation(t *testing.T, now time.Time) (*Engine, *simulation, *ManualClock) {
+	clock := NewManualClock(now)
+	sim := &simulation{fsys: NewMemFS(clock)}
+	return newSimulationEngine(t, sim, clock), sim, clock
+}
+
+// replace the store of the engine by a SQLite store
+func useSQLite(t *testing.T, e *Engine) {
+	e.Config.DBBackend = BackendSQLite
+	e.Config.DB = filepath.Join(t.TempDir(), "archive.sqlite")
+	store, err := OpenStore(e.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	e.Store = store
+}
+
+func addFrames(t *testing.T, fsys *MemFS, path string, modTime time.Time, names ...string) {
+	for _, name := range names {
+		err := fsys.AddFile(filepath.Join(path, name), []byte(name), modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const ds1 = "/storage/krios/user1/ds1"
+const ds2 = "/storage/scratch/user2/tmp"
+
+func TestSimulation(t *testing.T) {
+	allow := true
+	cases := []struct {
+		name string
+		// create the initial files, all at day 0
+		setup func(t *testing.T, fsys *MemFS)
+		// changes to the files, happen at 01:00 of the day
+		changes          map[int]func(t *testing.T, fsys *MemFS)
+		failArchiveUntil map[string]int
+		failNotifyDays   map[int]bool
+		unreadable       map[string][2]int
+		roots            []RootConfig // replace the root of the config if set
+		expected         []string
+	}{
+		{
+			name: "untouched dataset is archived after the notices",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif", "frames/2.tif", "info.txt")
+			},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames,info.txt",
+				"day 20: notice " + ds1 + " 10",
+				"day 25: notice " + ds1 + " 5",
+				"day 29: notice " + ds1 + " 1",
+				"day 30: archive " + ds1,
+			},
+		},
+		{
+			name: "folder at scan level is forced to be a dataset",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds2, simTime(0, 0), "a.txt", "sub/b.txt")
+			},
+			expected: []string{
+				"day 00: backup " + ds2 + " a.txt,sub",
+				"day 20: notice " + ds2 + " 10",
+				"day 25: notice " + ds2 + " 5",
+				"day 29: notice " + ds2 + " 1",
+				"day 30: archive " + ds2,
+			},
+		},
+		{
+			name: "modification before the notices postpones the archive",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif", "frames/2.tif", "info.txt")
+			},
+			changes: map[int]func(t *testing.T, fsys *MemFS){
+				15: func(t *testing.T, fsys *MemFS) {
+					addFrames(t, fsys, ds1, simTime(15, 1), "frames/3.tif")
+				},
+			},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames,info.txt",
+				"day 15: backup " + ds1 + " frames/3.tif",
+				"day 35: notice " + ds1 + " 10",
+				"day 40: notice " + ds1 + " 5",
+				"day 44: notice " + ds1 + " 1",
+				"day 45: archive " + ds1,
+			},
+		},
+		{
+			name: "modification after a notice sends the notices again",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif", "frames/2.tif", "info.txt")
+			},
+			changes: map[int]func(t *testing.T, fsys *MemFS){
+				22: func(t *testing.T, fsys *MemFS) {
+					addFrames(t, fsys, ds1, simTime(22, 1), "info.txt")
+				},
+			},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames,info.txt",
+				"day 20: notice " + ds1 + " 10",
+				// the change is found by the regular scan 3 days after the notice
+				"day 23: backup " + ds1 + " info.txt",
+				"day 42: notice " + ds1 + " 10",
+				"day 47: notice " + ds1 + " 5",
+				"day 51: notice " + ds1 + " 1",
+				"day 52: archive " + ds1,
+			},
+		},
+		{
+			name: "failed archive is retried the next day",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif")
+			},
+			failArchiveUntil: map[string]int{ds1: 31},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames",
+				"day 20: notice " + ds1 + " 10",
+				"day 25: notice " + ds1 + " 5",
+				"day 29: notice " + ds1 + " 1",
+				"day 30: error " + ds1 + ": archive of " + ds1 + " failed",
+				"day 31: archive " + ds1,
+			},
+		},
+		{
+			name: "dataset with an unreadable folder is not archived",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif", "frames/old/2.tif")
+			},
+			unreadable: map[string][2]int{ds1 + "/frames/old": {28, 32}},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames",
+				"day 20: notice " + ds1 + " 10",
+				"day 25: notice " + ds1 + " 5",
+				"day 28: error " + ds1 + ": the dataset is not archived: scan incomplete, 1 entries can't be read: open " + ds1 + "/frames/old: permission denied",
+				"day 29: error " + ds1 + ": the dataset is not archived: scan incomplete, 1 entries can't be read: open " + ds1 + "/frames/old: permission denied",
+				"day 29: notice " + ds1 + " 1",
+				"day 30: error " + ds1 + ": the dataset is not archived: scan incomplete, 1 entries can't be read: open " + ds1 + "/frames/old: permission denied",
+				"day 31: error " + ds1 + ": the dataset is not archived: scan incomplete, 1 entries can't be read: open " + ds1 + "/frames/old: permission denied",
+				"day 32: archive " + ds1,
+			},
+		},
+		{
+			name: "notice is sent again when the email fails",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif")
+			},
+			failNotifyDays: map[int]bool{20: true, 21: true},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames",
+				"day 20: notify failed",
+				"day 21: notify failed",
+				"day 22: notice " + ds1 + " 8",
+				"day 25: notice " + ds1 + " 5",
+				"day 29: notice " + ds1 + " 1",
+				"day 30: archive " + ds1,
+			},
+		},
+		{
+			name: "deleted dataset is forgotten",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif")
+			},
+			changes: map[int]func(t *testing.T, fsys *MemFS){
+				10: func(t *testing.T, fsys *MemFS) {
+					fsys.RemoveAll(ds1)
+				},
+			},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames",
+			},
+		},
+		{
+			name: "dataset asks for a longer archive interval and no backup",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif")
+				fsys.AddFile(filepath.Join(ds1, DatasetFileName), []byte("id: ds1\narchive-interval: 45\nno-backup: true\n"), simTime(0, 0))
+			},
+			roots: []RootConfig{{Path: "/storage", MaxArchiveInterval: 60, AllowNoBackup: &allow}},
+			expected: []string{
+				"day 35: notice " + ds1 + " 10",
+				"day 40: notice " + ds1 + " 5",
+				"day 44: notice " + ds1 + " 1",
+				"day 45: archive " + ds1,
+			},
+		},
+		{
+			name: "the oldest dataset is archived early when the storage is full",
+			setup: func(t *testing.T, fsys *MemFS) {
+				fsys.Capacity = 3000
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif")
+				fsys.AddFile(filepath.Join(ds1, "frames/2.tif"), make([]byte, 1000), simTime(0, 0))
+				fsys.AddFile(filepath.Join(ds2, "a.bin"), make([]byte, 1000), simTime(0, 0))
+			},
+			changes: map[int]func(t *testing.T, fsys *MemFS){
+				3: func(t *testing.T, fsys *MemFS) {
+					addFrames(t, fsys, ds2, simTime(3, 1), "b.txt")
+				},
+			},
+			roots: []RootConfig{{Path: "/storage", CapacityHighWatermark: 60, CapacityLowWatermark: 40, CapacityMinAge: 7, CapacityNoticeDays: 7}},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames",
+				"day 00: backup " + ds2 + " a.bin",
+				"day 03: backup " + ds2 + " b.txt",
+				"day 07: notice " + ds1 + " 7 capacity",
+				"day 09: notice " + ds1 + " 5 capacity",
+				"day 13: notice " + ds1 + " 1 capacity",
+				"day 14: archive " + ds1,
+				"day 23: notice " + ds2 + " 10",
+				"day 28: notice " + ds2 + " 5",
+				"day 32: notice " + ds2 + " 1",
+				"day 33: archive " + ds2,
+			},
+		},
+		{
+			name: "restored files count with the ctime signal",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif")
+			},
+			changes: map[int]func(t *testing.T, fsys *MemFS){
+				10: func(t *testing.T, fsys *MemFS) { // cp -p of an old file
+					addFrames(t, fsys, ds1, simTime(10, 1), "frames/2.tif")
+					fsys.Chtimes(filepath.Join(ds1, "frames/2.tif"), simTime(0, 0))
+					fsys.Chtimes(filepath.Join(ds1, "frames"), simTime(0, 0))
+				},
+			},
+			roots: []RootConfig{{Path: "/storage", Activity: []string{SignalCtime}}},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames",
+				"day 30: notice " + ds1 + " 10",
+				"day 35: notice " + ds1 + " 5",
+				"day 39: notice " + ds1 + " 1",
+				"day 40: archive " + ds1,
+			},
+		},
+		{
+			name: "roots have their own policies",
+			setup: func(t *testing.T, fsys *MemFS) {
+				addFrames(t, fsys, ds1, simTime(0, 0), "frames/1.tif")
+				addFrames(t, fsys, ds2, simTime(0, 0), "a.txt")
+			},
+			roots: []RootConfig{
+				{Path: "/storage/krios", ArchiveInterval: 20, NoticeBefore: []int{3}},
+				{Path: "/storage/scratch", ScanLevel: 2, ArchiveInterval: 14, NoticeBefore: []int{7}},
+			},
+			expected: []string{
+				"day 00: backup " + ds1 + " frames",
+				"day 00: backup " + ds2 + " a.txt",
+				"day 07: notice " + ds2 + " 7",
+				"day 14: archive " + ds2,
+				"day 17: notice " + ds1 + " 3",
+				"day 20: archive " + ds1,
+			},
+		},
+	}
+	for _, backend := range StoreBackends {
+		for _, c := range cases {
+			t.Run(backend+"/"+c.name, func(t *testing.T) {
+				e, sim, clock := newSimulation(t, simTime(0, 0))
+				sim.failArchiveUntil, sim.failNotifyDays, sim.unreadable = c.failArchiveUntil, c.failNotifyDays, c.unreadable
+				c.setup(t, sim.fsys)
+				if backend == BackendSQLite {
+					useSQLite(t, e)
+				}
+				if c.roots != nil {
+					e.Config.Root = ""
+					e.Config.Roots = c.roots
+				}
+				for day := 0; day < simDays; day++ {
+					sim.day = day
+					if change, ok := c.changes[day]; ok {
+						clock.Set(simTime(day, 1))
+						change(t, sim.fsys)
+					}
+					clock.Set(simTime(day, 2))
+					if err := e.Discover(); err != nil {
+						t.Fatalf("day %d: discover: %v", day, err)
+					}
+					result, err := e.Scan()
+					if err != nil {
+						t.Fatalf("day %d: scan: %v", day, err)
+					}
+					if err := e.Notify(result); err != nil && !c.failNotifyDays[day] {
+						t.Fatalf("day %d: notify: %v", day, err)
+					}
+				}
+				sort.Strings(sim.events)
+				if strings.Join(sim.events, "\n") != strings.Join(c.expected, "\n") {
+					t.Errorf("events:\n%s\nexpected:\n%s", strings.Join(sim.events, "\n"), strings.Join(c.expected, "\n"))
+				}
+			})
+		}
+	}
+}
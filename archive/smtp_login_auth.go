@@ -1,5 +1,5 @@
 // MIT license (c) andelf 2013
-package main
+package archive
 
 import (
 	"errors"
@@ -0,0 +1,285 @@
+package archive
+
+import (
+	"database/sql"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// changeSource tells the changes in the watched folders, inotify on linux
+type changeSource interface {
+	Add(dir string) error
+	Remove(dir string) error
+	Close() error
+}
+
+// errWatchLimit is returned by changeSource.Add when the source can't watch more folders
+var errWatchLimit = errors.New("the inotify watches are used up, raise fs.inotify.max_user_watches")
+
+// change is an event of a changeSource
+type change struct {
+	Dir      string // the watched folder
+	Name     string // the entry changed in the folder, empty for the folder itself
+	NewDir   bool   // a folder is created or moved in, it's watched too
+	Attrib   bool   // only the metadata changed, e.g. by chmod or touch
+	Overflow bool   // events are lost, the changes of all the datasets are not known
+}
+
+// Watcher follows the changes of the datasets between the scans, for the roots with watch set.
+// A watched dataset which isn't changed since its last scan isn't walked again, and isn't backed up.
+// It's still walked before it's archived, the changes made by other NFS clients are not seen.
+// The datasets which can't be watched are scanned as usual.
+type Watcher struct {
+	e      *Engine
+	source changeSource
+
+	mu       sync.Mutex
+	dirs     map[string]string           // watched folder -> path of its dataset
+	datasets map[string]*watchedDataset  // by path
+	pending  map[string]*pendingActivity // by path of the dataset, the changes not saved yet
+}
+
+type watchedDataset struct {
+	id     string
+	policy *Policy
+	since  time.Time // the changes are followed since, zero if some folders can't be watched
+}
+
+type pendingActivity struct {
+	id       string
+	activity Activity
+}
+
+// StartWatcher starts following the changes of the active datasets, the engine uses it for the next scans.
+// Sync must be called after every run to follow the new datasets.
+func (e *Engine) StartWatcher() (*Watcher, error) {
+	w := newWatcher(e)
+	source, err := newChangeSource(w.handle)
+	if err != nil {
+		return nil, err
+	}
+	w.source = source
+	e.Watcher = w
+	return w, w.Sync()
+}
+
+func newWatcher(e *Engine) *Watcher {
+	return &Watcher{
+		e:        e,
+		dirs:     make(map[string]string),
+		datasets: make(map[string]*watchedDataset),
+		pending:  make(map[string]*pendingActivity),
+	}
+}
+
+// Sync watches the new active datasets of the roots with watch set, and forgets the ones gone.
+// The datasets not completely watched after a lost event are watched again.
+func (w *Watcher) Sync() error {
+	records, err := w.e.Store.ListActiveRecords()
+	if err != nil {
+		return errors.Wrap(err, "error reading active records")
+	}
+	policies := w.e.Config.Policies()
+	active := make(map[string]bool)
+	unwatched := 0 // after the watches are used up
+	for _, r := range records {
+		policy := policyOf(policies, r.Path)
+		if policy == nil || !policy.Watch || r.MissingSince.Valid {
+			continue
+		}
+		policy = w.e.datasetPolicy(policy, r.Path)
+		if containsString(policy.Activity, SignalAtime) { // the reads are not followed
+			continue
+		}
+		active[r.Path] = true
+		w.mu.Lock()
+		d, ok := w.datasets[r.Path]
+		if ok && d.id == r.ID {
+			d.policy = policy
+		} else {
+			d = &watchedDataset{id: r.ID, policy: policy}
+			w.datasets[r.Path] = d
+		}
+		if !d.since.IsZero() {
+			w.mu.Unlock()
+			continue
+		}
+		if unwatched > 0 {
+			unwatched++
+			w.mu.Unlock()
+			continue
+		}
+		// the changes made while the folders are added are followed too
+		d.since = w.e.Clock.Now()
+		w.mu.Unlock()
+		if err := w.addTree(r.Path, r.Path); errors.Is(err, errWatchLimit) {
+			// the folders watched so far are left to the other datasets
+			w.mu.Lock()
+			d.since = time.Time{}
+			w.unwatch(r.Path)
+			w.mu.Unlock()
+			unwatched++
+		} else if err != nil {
+			log.Printf("can't watch dataset %s, it's scanned as usual, error: %v", r.Path, err)
+			w.mu.Lock()
+			d.since = time.Time{}
+			w.mu.Unlock()
+		}
+	}
+	if unwatched > 0 {
+		log.Printf("%v: %d datasets are not watched, they are scanned by the daily runs", errWatchLimit, unwatched)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path := range w.datasets {
+		if !active[path] {
+			w.forget(path)
+		}
+	}
+	return nil
+}
+
+// watch the folder and the folders inside it
+func (w *Watcher) addTree(dataset string, dir string) error {
+	if err := w.source.Add(dir); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.dirs[dir] = dataset
+	w.mu.Unlock()
+	entries, err := w.e.FS.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() { // symbolic links are not followed
+			if err := w.addTree(dataset, filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stop watching a dataset, w.mu must be held
+func (w *Watcher) forget(path string) {
+	w.unwatch(path)
+	delete(w.datasets, path)
+	delete(w.pending, path)
+}
+
+// remove the watches of the folders of a dataset, w.mu must be held
+func (w *Watcher) unwatch(path string) {
+	for dir, dataset := range w.dirs {
+		if dataset == path {
+			w.source.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+}
+
+func (w *Watcher) handle(c change) {
+	now := w.e.Clock.Now()
+	w.mu.Lock()
+	if c.Overflow {
+		log.Println("watch events are lost, the datasets are scanned as usual until they are watched again")
+		for _, d := range w.datasets {
+			d.since = time.Time{}
+		}
+		w.mu.Unlock()
+		return
+	}
+	dataset, ok := w.dirs[c.Dir]
+	d := w.datasets[dataset]
+	if !ok || d == nil {
+		w.mu.Unlock()
+		return
+	}
+	signal := SignalMtime
+	if c.Dir == dataset && c.Name == DatasetFileName {
+		w.mu.Unlock()
+		return
+	} else if c.Dir == dataset && c.Name == KeepaliveFileName {
+		if !containsString(d.policy.Activity, SignalKeepalive) {
+			w.mu.Unlock()
+			return
+		}
+		signal = SignalKeepalive
+	} else if c.Attrib {
+		if !containsString(d.policy.Activity, SignalCtime) {
+			w.mu.Unlock()
+			return
+		}
+		signal = SignalCtime
+	}
+	p, ok := w.pending[dataset]
+	if !ok {
+		p = &pendingActivity{id: d.id}
+		w.pending[dataset] = p
+	}
+	p.activity.observe(now, signal)
+	w.mu.Unlock()
+
+	if c.NewDir {
+		if err := w.addTree(dataset, filepath.Join(c.Dir, c.Name)); err != nil {
+			log.Printf("can't watch the new folder of dataset %s, it's scanned as usual, error: %v", dataset, err)
+			w.mu.Lock()
+			d.since = time.Time{}
+			if errors.Is(err, errWatchLimit) {
+				w.unwatch(dataset)
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Flush saves the changes followed since the last flush to the records, the changed datasets are marked dirty.
+func (w *Watcher) Flush() error {
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = make(map[string]*pendingActivity)
+	w.mu.Unlock()
+	for path, p := range pending {
+		record, err := w.e.Store.GetRecord(p.id)
+		if err != nil {
+			return errors.Wrapf(err, "error reading the record of %s", path)
+		}
+		if record == nil { // archived or removed
+			continue
+		}
+		if !record.LastModifyTime.Valid || p.activity.Time.After(record.LastModifyTime.Time) {
+			if record.LastModifyTime.Valid {
+				// the folder is modified again, the notices already sent are not valid any more
+				record.NoticedLeftDays = 0
+				record.CapacityDeadline = sql.NullTime{}
+			}
+			record.LastModifyTime = sql.NullTime{Time: p.activity.Time, Valid: true}
+			record.ActivitySignal = p.activity.Signal
+		}
+		record.Dirty = true
+		if err := w.e.Store.UpdateRecord(record); err != nil {
+			return errors.Wrapf(err, "error updating the record of %s", path)
+		}
+	}
+	return nil
+}
+
+// covers reports if all the changes of the dataset since its last scan are known
+func (w *Watcher) covers(record *DatasetRecord) bool {
+	if w == nil || !record.ScanTime.Valid || record.Unreadable > 0 {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d := w.datasets[record.Path]
+	return d != nil && d.id == record.ID && !d.since.IsZero() && record.ScanTime.Time.After(d.since)
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return w.source.Close()
+}
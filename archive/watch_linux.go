@@ -0,0 +1,114 @@
+package archive
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// inotifySource watches every folder with inotify, the number of folders is limited by fs.inotify.max_user_watches.
+// fanotify is not used: it needs CAP_SYS_ADMIN, and the folders of the changes are only reported since linux 5.9.
+type inotifySource struct {
+	file *os.File
+	fd   int
+
+	mu    sync.Mutex
+	wds   map[int]string // watch descriptor -> folder
+	paths map[string]int
+}
+
+func newChangeSource(handle func(change)) (changeSource, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init inotify")
+	}
+	s := &inotifySource{
+		file:  os.NewFile(uintptr(fd), "inotify"), // non blocking, Close stops the read
+		fd:    fd,
+		wds:   make(map[int]string),
+		paths: make(map[string]int),
+	}
+	go s.read(handle)
+	return s, nil
+}
+
+func (s *inotifySource) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(s.fd, dir, inotifyMask)
+	if err == syscall.ENOSPC {
+		return errWatchLimit
+	} else if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wds[wd] = dir
+	s.paths[dir] = wd
+	return nil
+}
+
+func (s *inotifySource) Remove(dir string) error {
+	s.mu.Lock()
+	wd, ok := s.paths[dir]
+	delete(s.paths, dir)
+	delete(s.wds, wd)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	_, err := syscall.InotifyRmWatch(s.fd, uint32(wd))
+	if err != nil && err != syscall.EINVAL { // EINVAL if the folder is removed
+		return &os.PathError{Op: "inotify_rm_watch", Path: dir, Err: err}
+	}
+	return nil
+}
+
+func (s *inotifySource) Close() error {
+	return s.file.Close()
+}
+
+func (s *inotifySource) read(handle func(change)) {
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	for {
+		n, err := s.file.Read(buf[:])
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Printf("error reading inotify events: %v", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				handle(change{Overflow: true})
+				continue
+			}
+			s.mu.Lock()
+			dir, ok := s.wds[int(event.Wd)]
+			if ok && event.Mask&syscall.IN_IGNORED != 0 { // the folder is removed
+				delete(s.wds, int(event.Wd))
+				delete(s.paths, dir)
+			}
+			s.mu.Unlock()
+			if !ok || event.Mask&syscall.IN_IGNORED != 0 {
+				continue
+			}
+			handle(change{
+				Dir:    dir,
+				Name:   name,
+				NewDir: event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0,
+				Attrib: event.Mask&^syscall.IN_ISDIR == syscall.IN_ATTRIB,
+			})
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package archive
+
+import "github.com/pkg/errors"
+
+func newChangeSource(handle func(change)) (changeSource, error) {
+	return nil, errors.New("watching folders is only supported on linux")
+}
@@ -0,0 +1,166 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSource is a changeSource without events, the test calls Watcher.handle
+type fakeSource struct {
+	dirs  map[string]bool
+	limit int // the number of watches, unlimited when 0
+}
+
+func (s *fakeSource) Add(dir string) error {
+	if s.limit > 0 && len(s.dirs) >= s.limit {
+		return errWatchLimit
+	}
+	s.dirs[dir] = true
+	return nil
+}
+
+func (s *fakeSource) Remove(dir string) error {
+	delete(s.dirs, dir)
+	return nil
+}
+
+func (s *fakeSource) Close() error {
+	return nil
+}
+
+func TestWatcher(t *testing.T) {
+	e, sim, clock := newSimulation(t, simTime(0, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	e.Config.Watch = true
+	run := func(day int) *DatasetRecord {
+		sim.day = day
+		clock.Set(simTime(day, 2))
+		if e.Watcher != nil {
+			if err := e.Watcher.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Discover(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Scan(); err != nil {
+			t.Fatal(err)
+		}
+		records, _ := e.Store.ListActiveRecords()
+		return &records[0]
+	}
+	run(0)
+
+	w := newWatcher(e)
+	source := &fakeSource{dirs: map[string]bool{}}
+	w.source = source
+	e.Watcher = w
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !source.dirs[ds1] || !source.dirs[ds1+"/frames"] {
+		t.Fatalf("the folders of the dataset are not watched: %v", source.dirs)
+	}
+	record := run(3) // walked once more, the changes before the watch started are found
+	if w.covers(record) != true {
+		t.Errorf("the dataset must be covered by the watcher after it's scanned")
+	}
+
+	// not seen by the watcher, the dataset isn't walked
+	addFrames(t, sim.fsys, ds1, simTime(4, 1), "frames/unseen.tif")
+	lastModifyTime := record.LastModifyTime.Time
+	record = run(6)
+	if !record.LastModifyTime.Time.Equal(lastModifyTime) {
+		t.Errorf("an unchanged watched dataset must not be walked, modify time %v", record.LastModifyTime.Time)
+	}
+
+	clock.Set(simTime(7, 1))
+	w.handle(change{Dir: ds1, Name: DatasetFileName})
+	w.handle(change{Dir: ds1 + "/frames", Name: "2.tif"})
+	w.handle(change{Dir: ds1 + "/frames", Name: "new", NewDir: true})
+	sim.fsys.MkdirAll(ds1+"/frames/new", simTime(7, 1))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	record, _ = e.Store.GetRecord(record.ID)
+	if !record.Dirty || !record.LastModifyTime.Time.Equal(simTime(7, 1)) || record.ActivitySignal != SignalMtime {
+		t.Errorf("the change is not saved: %+v", record)
+	}
+	record = run(9) // the dirty dataset is walked and backed up
+	if record.Dirty || !record.LastModifyTime.Time.Equal(simTime(7, 1)) {
+		t.Errorf("the dirty dataset is not walked: %+v", record)
+	}
+
+	w.handle(change{Overflow: true})
+	if w.covers(record) {
+		t.Errorf("the dataset must not be covered after events are lost")
+	}
+	expected := []string{
+		"day 00: backup " + ds1 + " frames",
+		"day 09: backup " + ds1 + " frames/unseen.tif",
+	}
+	if strings.Join(sim.events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("events:\n%s\nexpected:\n%s", strings.Join(sim.events, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestWatchLimit(t *testing.T) {
+	e, sim, _ := newSimulation(t, simTime(0, 2))
+	addFrames(t, sim.fsys, ds1, simTime(0, 0), "frames/1.tif")
+	e.Config.Watch = true
+	if err := e.Discover(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newWatcher(e)
+	source := &fakeSource{dirs: map[string]bool{}, limit: 1}
+	w.source = source
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(source.dirs) != 0 {
+		t.Fatalf("the watches of a partly watched dataset are kept: %v", source.dirs)
+	}
+	records, _ := e.Store.ListActiveRecords()
+	if w.covers(&records[0]) {
+		t.Fatal("a partly watched dataset is not scanned by the daily runs")
+	}
+}
+
+func TestInotifySource(t *testing.T) {
+	changes := make(chan change, 10)
+	source, err := newChangeSource(func(c change) { changes <- c })
+	if err != nil {
+		t.Skip(err)
+	}
+	defer source.Close()
+	dir := t.TempDir()
+	if err := source.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	timeout := time.After(5 * time.Second)
+	seen := map[string]bool{}
+	for !seen["a.txt"] || !seen["sub"] {
+		select {
+		case c := <-changes:
+			if c.Dir != dir {
+				t.Fatalf("unexpected change %+v", c)
+			}
+			if c.Name == "sub" && !c.NewDir {
+				t.Errorf("the new folder is not reported: %+v", c)
+			}
+			seen[c.Name] = true
+		case <-timeout:
+			t.Fatalf("changes not seen: %v", seen)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+	"rubenlab.org/autoarchive/archive"
+)
+
+// checkConfig validates a config file, then checks the root folder,
+// the database, the commands and the smtp server it refers to.
+func checkConfig(args []string) int {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	printConfig := flags.Bool("print", false, "print the effective config, secrets are hidden")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive check-config [-print] config.yml")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	configFile := flags.Arg(0)
+	if configFile == "" {
+		flags.Usage()
+		return 2
+	}
+	failed := false
+	config, err := archive.LoadConfig(configFile)
+	if err != nil {
+		fmt.Printf("FAIL  config: %v\n", err)
+		if config == nil {
+			return 1
+		}
+		failed = true
+	} else {
+		fmt.Println("ok    config")
+	}
+	for _, result := range archive.CheckEnvironment(config) {
+		if result.Err != nil {
+			fmt.Printf("FAIL  %s: %v\n", result.Name, result.Err)
+			failed = true
+		} else {
+			fmt.Printf("ok    %s\n", result.Name)
+		}
+	}
+	if *printConfig {
+		data, err := yaml.Marshal(config)
+		if err != nil {
+			fmt.Printf("FAIL  print config: %v\n", err)
+			return 1
+		}
+		fmt.Printf("\n%s", data)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
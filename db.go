@@ -1,150 +1,177 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
-	"encoding/gob"
-
-	bolt "go.etcd.io/bbolt"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"rubenlab.org/autoarchive/archive"
 )
 
-const Bucket_Active = "active"
-const Bucket_Archived = "archived"
-
-type DatasetRecord struct {
-	ID              string
-	Path            string
-	LastModifyTime  sql.NullTime // the latest modify time of any file in the folder
-	ScanTime        sql.NullTime // when it's last scanned
-	NoticedLeftDays int          // Reminder for archiving in NoticedLeftDays have been sent
-	ArchiveTime     sql.NullTime // When record is archived
-}
-
-var currentDb *bolt.DB = nil
-
-func initDb() (*bolt.DB, error) {
-	db, err := bolt.Open(appConfig.DB, 0600, nil)
+// dbCommand copies, compacts or migrates the database
+func dbCommand(args []string) int {
+	flags := flag.NewFlagSet("db", flag.ExitOnError)
+	wait := flags.Duration("wait", time.Minute, "how long to wait for a run using the bolt database")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive db backup [-wait duration] config.yml dest")
+		fmt.Fprintln(flags.Output(), "   or: autoarchive db compact [-wait duration] config.yml")
+		fmt.Fprintln(flags.Output(), "   or: autoarchive db migrate [-wait duration] config.yml bolt-file")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+	action := args[0]
+	flags.Parse(args[1:])
+	configFile := flags.Arg(0)
+	switch {
+	case (action == "backup" || action == "migrate") && configFile != "" && flags.Arg(1) != "":
+	case action == "compact" && configFile != "" && flags.Arg(1) == "":
+	default:
+		flags.Usage()
+		return 2
+	}
+	config, err := archive.LoadConfig(configFile)
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(os.Stderr, "can't load config, err: %v\n", err)
+		return 1
+	}
+	switch action {
+	case "backup":
+		return backupDB(config, flags.Arg(1), *wait)
+	case "migrate":
+		return migrateDB(config, flags.Arg(1), *wait)
+	}
+
+	// the runs don't start while the database is rewritten
+	pidLock, lockErr := tryLock(config)
+	if lockErr != nil {
+		fmt.Fprintln(os.Stderr, "failed to get lock, other autoarchive process is running")
+		return 1
+	}
+	if pidLock != nil {
+		defer pidLock.Unlock()
+	}
+	var before, after int64
+	if config.DBBackend == archive.BackendSQLite {
+		before, after, err = compactSQLite(config.DB)
+	} else {
+		before, after, err = archive.CompactBoltFile(config.DB, *wait)
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists([]byte(Bucket_Active))
-		if err != nil {
-			return err
-		}
-		_, err = tx.CreateBucketIfNotExists([]byte(Bucket_Archived))
-		return err
-	})
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(os.Stderr, "fail to compact the database, err: %v\n", err)
+		return 1
 	}
-	currentDb = db
-	return db, nil
+	fmt.Printf("database compacted from %d to %d bytes\n", before, after)
+	return 0
 }
 
-func AddRecord(record *DatasetRecord) error {
-	err := currentDb.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(Bucket_Active))
-		data, err := encodeRecord(record)
-		if err != nil {
-			return err
+func backupDB(config *archive.AppConfig, dest string, wait time.Duration) int {
+	var err error
+	if config.DBBackend == archive.BackendSQLite {
+		// SQLite copies the database while it's used by a run
+		err = backupSQLite(config.DB, dest)
+	} else {
+		// bolt locks the database file of a run, the process of the run copies it
+		err = archive.RequestBackup(config.BackupSocket(), dest)
+		if err == archive.ErrNoBackupServer {
+			err = archive.BackupBoltFile(config.DB, dest, wait)
 		}
-		bucket.Put([]byte(record.ID), data)
-		return nil
-	})
-	return err
-}
-
-func UpdateRecord(record *DatasetRecord) error {
-	return AddRecord(record)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to back up the database, err: %v\n", err)
+		return 1
+	}
+	fmt.Printf("database copied to %s\n", dest)
+	return 0
 }
 
-func GetRecord(id string) (*DatasetRecord, error) {
-	var record *DatasetRecord
-	err := currentDb.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(Bucket_Active))
-		data := bucket.Get([]byte(id))
-		if data == nil {
-			return nil
-		}
-		recordEntity, err := decodeRecord(data)
-		if err != nil {
-			return err
-		}
-		record = recordEntity
+// serveBackups lets db backup copy the bolt database while this process uses it, nil if it can't
+func serveBackups(engine *archive.Engine) io.Closer {
+	if engine.Config.DBBackend == archive.BackendSQLite {
 		return nil
-	})
-	return record, err
-}
-
-func encodeRecord(record *DatasetRecord) ([]byte, error) {
-	buf := bytes.Buffer{}
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(record)
+	}
+	server, err := engine.ServeBackups(engine.Config.BackupSocket())
 	if err != nil {
-		return nil, err
+		log.Printf("db backup can't copy the database during the runs, error: %v", err)
+		return nil
 	}
-	return buf.Bytes(), nil
+	return server
 }
 
-func decodeRecord(data []byte) (*DatasetRecord, error) {
-	d := DatasetRecord{}
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&d)
+func backupSQLite(path string, dest string) error {
+	// opening a missing database would create an empty one
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	store, err := archive.OpenSQLiteStore(path)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &d, nil
+	defer store.Close()
+	return store.Backup(dest)
 }
 
-func DeleteRecord(id string) error {
-	err := currentDb.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(Bucket_Active))
-		bucket.Delete([]byte(id))
-		return nil
-	})
-	return err
-}
-
-func SaveArchiveRecord(record *DatasetRecord) error {
-	err := currentDb.Update(func(tx *bolt.Tx) error {
-		activeBucket := tx.Bucket([]byte(Bucket_Active))
-		activeBucket.Delete([]byte(record.ID))
-		archiveBucket := tx.Bucket([]byte(Bucket_Archived))
-		data, err := encodeRecord(record)
-		if err != nil {
-			return err
-		}
-		archiveBucket.Put([]byte(record.ID), data)
-		return nil
-	})
-	return err
-}
-
-func ListActiveRecords() ([]DatasetRecord, error) {
-	return listBucketRecords(Bucket_Active)
+func compactSQLite(path string) (int64, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	store, err := archive.OpenSQLiteStore(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer store.Close()
+	if err := store.Compact(); err != nil {
+		return 0, 0, err
+	}
+	compacted, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), compacted.Size(), nil
 }
 
-func ListArchivedRecords() ([]DatasetRecord, error) {
-	return listBucketRecords(Bucket_Archived)
+// migrateDB copies the records of a bolt database into the new database of the config
+func migrateDB(config *archive.AppConfig, boltFile string, wait time.Duration) int {
+	if samePath(boltFile, config.DB) {
+		fmt.Fprintf(os.Stderr, "%s is the database of the config, the records can only be copied into a new database\n", boltFile)
+		return 1
+	}
+	src, err := archive.OpenBoltStoreReadOnly(boltFile, wait)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open the bolt database, err: %v\n", err)
+		return 1
+	}
+	defer src.Close()
+	dst, err := archive.OpenStore(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open database, err: %v\n", err)
+		return 1
+	}
+	defer dst.Close()
+	count, err := archive.CopyRecords(src, dst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to migrate after %d records, err: %v\n", count, err)
+		return 1
+	}
+	fmt.Printf("%d records copied into %s\n", count, config.DB)
+	return 0
 }
 
-func listBucketRecords(bucketName string) ([]DatasetRecord, error) {
-	list := make([]DatasetRecord, 0, 10)
-	err := currentDb.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		err := bucket.ForEach(func(k, v []byte) error {
-			record, err := decodeRecord(v)
-			if err != nil {
-				return err
-			}
-			list = append(list, *record)
-			return nil
-		})
-		return err
-	})
-	if err != nil {
-		return nil, err
+// if the paths are the same file, or the same path when one doesn't exist
+func samePath(a string, b string) bool {
+	aInfo, aErr := os.Stat(a)
+	bInfo, bErr := os.Stat(b)
+	if aErr == nil && bErr == nil {
+		return os.SameFile(aInfo, bInfo)
 	}
-	return list, nil
+	aAbs, _ := filepath.Abs(a)
+	bAbs, _ := filepath.Abs(b)
+	return aAbs == bAbs
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"rubenlab.org/autoarchive/archive"
+)
+
+// exportRecords writes the records of the database to the standard output
+func exportRecords(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "jsonl", "output format: "+strings.Join(archive.ExportFormats, ", "))
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive export [-format jsonl|csv] config.yml")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	configFile := flags.Arg(0)
+	if configFile == "" {
+		flags.Usage()
+		return 2
+	}
+	_, store, code := openStore(configFile)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+	if err := archive.ExportRecords(store, os.Stdout, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "fail to export, err: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// importRecords reads the records of an export into a new database
+func importRecords(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "jsonl", "input format: "+strings.Join(archive.ExportFormats, ", "))
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive import [-format jsonl|csv] config.yml export-file")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	configFile := flags.Arg(0)
+	exportFile := flags.Arg(1)
+	if configFile == "" || exportFile == "" {
+		flags.Usage()
+		return 2
+	}
+	in, err := os.Open(exportFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open the export, err: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+	_, store, code := openStore(configFile)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+	count, err := archive.ImportRecords(store, in, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to import, %d records imported, err: %v\n", count, err)
+		return 1
+	}
+	fmt.Printf("%d records imported\n", count)
+	return 0
+}
+
+// load the config and open its database, a nil store and the exit code if it fails
+func openStore(configFile string) (*archive.AppConfig, archive.Store, int) {
+	config, err := archive.LoadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't load config, err: %v\n", err)
+		return nil, nil, 1
+	}
+	store, err := archive.OpenStore(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open database, err: %v\n", err)
+		return nil, nil, 1
+	}
+	return config, store, 0
+}
@@ -0,0 +1,234 @@
+// Package smtptest provides an SMTP server running in the process,
+// it keeps the received emails in memory so tests can check them.
+package smtptest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message is an email received by the server
+type Message struct {
+	User    string // user of the AUTH command, empty without authentication
+	From    string
+	To      []string
+	Subject string
+	Body    string // decoded body of the email
+	Data    []byte // raw data as sent by the client
+}
+
+// Server is a minimal SMTP server listening on the loopback interface.
+// It accepts every email, AUTH PLAIN and AUTH LOGIN accept any password.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Host and Port of the server, to put into the smtp config
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Messages returns the emails received so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := make([]Message, len(s.messages))
+	copy(messages, s.messages)
+	return messages
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *Server) handle(c *textproto.Conn) {
+	c.PrintfLine("220 smtptest ESMTP ready")
+	var msg Message
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			c.PrintfLine("250 smtptest")
+		case "EHLO":
+			c.PrintfLine("250-smtptest")
+			c.PrintfLine("250-8BITMIME")
+			c.PrintfLine("250 AUTH PLAIN LOGIN")
+		case "AUTH":
+			user, ok := readAuth(c, arg)
+			if !ok {
+				c.PrintfLine("501 malformed authentication")
+				continue
+			}
+			msg.User = user
+			c.PrintfLine("235 authentication succeeded")
+		case "MAIL":
+			msg.From = trimAddress(arg, "FROM:")
+			msg.To = nil
+			c.PrintfLine("250 ok")
+		case "RCPT":
+			msg.To = append(msg.To, trimAddress(arg, "TO:"))
+			c.PrintfLine("250 ok")
+		case "DATA":
+			c.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			msg.Subject, msg.Body = parseData(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = Message{User: msg.User}
+			c.PrintfLine("250 ok")
+		case "RSET":
+			msg = Message{User: msg.User}
+			c.PrintfLine("250 ok")
+		case "NOOP":
+			c.PrintfLine("250 ok")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// read the AUTH exchange, return the user name
+func readAuth(c *textproto.Conn, arg string) (string, bool) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return "", false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "PLAIN":
+		var encoded string
+		if len(fields) > 1 {
+			encoded = fields[1]
+		} else {
+			c.PrintfLine("334 ")
+			line, err := c.ReadLine()
+			if err != nil {
+				return "", false
+			}
+			encoded = line
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", false
+		}
+		// identity \0 user \0 password
+		parts := bytes.Split(decoded, []byte{0})
+		if len(parts) != 3 {
+			return "", false
+		}
+		return string(parts[1]), true
+	case "LOGIN":
+		c.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+		user, err := readBase64Line(c)
+		if err != nil {
+			return "", false
+		}
+		c.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+		if _, err := readBase64Line(c); err != nil {
+			return "", false
+		}
+		return user, true
+	}
+	return "", false
+}
+
+func readBase64Line(c *textproto.Conn) (string, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(line)
+	return string(decoded), err
+}
+
+func trimAddress(arg string, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	if i := strings.IndexByte(arg, ' '); i >= 0 { // drop parameters like BODY=8BITMIME
+		arg = arg[:i]
+	}
+	return strings.Trim(arg, "<>")
+}
+
+// get the subject and the decoded body, the raw data is kept if it can't be parsed
+func parseData(data []byte) (string, string) {
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return "", string(data)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+	body, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		return subject, ""
+	}
+	if strings.EqualFold(m.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		decoded, err := ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+		if err == nil {
+			body = decoded
+		}
+	}
+	return subject, string(body)
+}
@@ -6,27 +6,29 @@ import (
 	"os"
 	"path/filepath"
 	"time"
-)
 
-var logOutputFolder string
+	"rubenlab.org/autoarchive/archive"
+)
 
 const logFileName = "autoarchive.log"
 
-func initLog() (io.Closer, error) {
-	logFolder := appConfig.LogFolder
+// initLog redirects the log to a file under config.LogFolder,
+// return the folder of the log file, the output of commands are written there too.
+func initLog(config *archive.AppConfig) (string, io.Closer, error) {
+	logFolder := config.LogFolder
 	if logFolder == "" {
 		log.Println("warning: LogFolder is empty, logs will be written to current working directory")
 	}
 	logStartTime := time.Now()
 	dateStr := logStartTime.Format("2006-01-02")
-	logOutputFolder = filepath.Join(logFolder, dateStr)
-	os.MkdirAll(logOutputFolder, FolderModeCreate)
+	logOutputFolder := filepath.Join(logFolder, dateStr)
+	os.MkdirAll(logOutputFolder, archive.FolderModeCreate)
 	logFile := filepath.Join(logOutputFolder, logFileName)
-	file, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE, FileModeCreate)
+	file, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE, archive.FileModeCreate)
 	if err != nil {
-		return nil, err
+		return logOutputFolder, nil, err
 	}
 	log.Printf("write log output to file: %s\n", logFile)
 	log.SetOutput(file)
-	return file, err
+	return logOutputFolder, file, err
 }
@@ -1,42 +1,73 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 
 	"github.com/nightlyone/lockfile"
+	"rubenlab.org/autoarchive/archive"
 )
 
+// subcommands are given as the first argument, before the config file
+var subcommands = map[string]func(args []string) int{
+	"check-config": checkConfig,
+	"report":       usageReport,
+	"watch":        watchDaemon,
+	"record":       showRecord,
+	"export":       exportRecords,
+	"import":       importRecords,
+	"rebuild-db":   rebuildDB,
+	"db":           dbCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
 	inspectV := flag.Bool("inspect", false, "inspect existing records")
 	loadBalance := flag.Bool("load-balance", false, "load balance existing records")
 	flag.Parse()
 	configFile := flag.Arg(0)
 	if configFile == "" {
 		fmt.Println("Please provide a config file, usage: autoarchive config.yml")
+		fmt.Println("or check a config file: autoarchive check-config config.yml")
+		fmt.Println("or report the storage usage: autoarchive report [-format text|csv|json|html] [-email] config.yml")
+		fmt.Println("or run every day and watch the datasets: autoarchive watch [-run-at HH:MM] config.yml")
+		fmt.Println("or show the record of a dataset: autoarchive record config.yml id|path")
+		fmt.Println("or export the database: autoarchive export [-format jsonl|csv] config.yml")
+		fmt.Println("or import an export into a new database: autoarchive import [-format jsonl|csv] config.yml export-file")
+		fmt.Println("or rebuild a lost database from the dataset folders and the archive logs: autoarchive rebuild-db [-archived] config.yml")
+		fmt.Println("or copy or compact the database: autoarchive db backup config.yml dest, autoarchive db compact config.yml")
+		fmt.Println("or copy a bolt database into the database of the config: autoarchive db migrate config.yml bolt-file")
 		os.Exit(1)
 	}
-	err := loadConfig(configFile)
+	config, err := archive.LoadConfig(configFile)
 	if err != nil {
 		log.Fatalf("can't load config, err: %v", err)
 	}
-	_, err = initDb()
+	store, err := archive.OpenStore(config)
 	if err != nil {
 		log.Fatalf("can't init db, error: %v\n", err)
 	}
+	defer store.Close()
+	engine := archive.NewEngine(config, store)
 	// initialization finish
 
 	if *inspectV {
-		err = inspect()
+		err = inspect(engine)
 		if err != nil {
 			log.Fatalf("fail to inspect, err: %v", err)
 		}
 		return
 	} else if *loadBalance {
 		log.Println("start load balance")
-		err = LoadBalancing()
+		err = engine.LoadBalancing()
 		if err != nil {
 			log.Fatalf("fail to load balance, err: %v", err)
 		}
@@ -45,7 +76,7 @@ func main() {
 	}
 
 	// init log, log after here will be written to config.LogFolder
-	logCloser, logErr := initLog()
+	logOutputFolder, logCloser, logErr := initLog(config)
 	if logErr != nil {
 		log.Printf("init log error, error is: %v", logErr)
 	}
@@ -54,9 +85,10 @@ func main() {
 			logCloser.Close()
 		}
 	}()
+	engine.SetCommandLogFolder(logOutputFolder)
 
 	// get pid lock, avoid concurrent execution
-	pidLock, lockErr := tryLock()
+	pidLock, lockErr := tryLock(config)
 	defer func() {
 		if pidLock != nil {
 			pidLock.Unlock()
@@ -66,33 +98,68 @@ func main() {
 		log.Println("failed to get lock, other autoarchive process is running")
 		os.Exit(1)
 	}
+	if backups := serveBackups(engine); backups != nil {
+		defer backups.Close()
+	}
 
 	// do auto archiving
 	log.Println("start auto archive")
-	autoArchive()
+	autoArchive(engine)
 	log.Println("finish auto archive")
 }
 
-func autoArchive() {
-	err := ScanFolders(appConfig.Root)
+func autoArchive(engine *archive.Engine) {
+	if err := runAutoArchive(engine); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runAutoArchive returns the error which stops the run, the other errors are logged
+func runAutoArchive(engine *archive.Engine) error {
+	// a copy of the database to roll back a bad run
+	snapshot, err := engine.Snapshot()
+	if err != nil {
+		log.Printf("error in database snapshot, error: %v", err)
+	} else if snapshot != "" {
+		log.Printf("database copied to %s", snapshot)
+	}
+	err = engine.Discover()
 	if err != nil {
 		log.Println(err)
 	}
-	scanResult, err := ScanRecords()
+	scanResult, err := engine.Scan()
 	if err != nil {
-		log.Fatalf("error in scan records, error: %v", err)
+		return fmt.Errorf("error in scan records, error: %v", err)
 	}
-	err = SendNotice(scanResult)
+	err = engine.Notify(scanResult)
 	if err != nil {
 		log.Printf("error send notice, error: %v", err)
 	}
+	err = engine.SendMonthlyUsageReport()
+	if err != nil {
+		log.Printf("error send usage report, error: %v", err)
+	}
+	return nil
+}
+
+func inspect(engine *archive.Engine) error {
+	result, err := engine.Inspect()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
 }
 
-func tryLock() (*lockfile.Lockfile, error) {
-	if appConfig.PidFile == "" {
+func tryLock(config *archive.AppConfig) (*lockfile.Lockfile, error) {
+	if config.PidFile == "" {
 		return nil, nil
 	}
-	fileLock, err := lockfile.New(appConfig.PidFile)
+	fileLock, err := lockfile.New(config.PidFile)
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
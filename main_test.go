@@ -1,67 +1,319 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"rubenlab.org/autoarchive/archive"
+	"rubenlab.org/autoarchive/internal/smtptest"
 )
 
-func TestLoadConfig(t *testing.T) {
-	err := loadConfig("./config-test.yml")
+// harness runs the whole auto archive pipeline on a storage tree in a temporary folder,
+// with a temporary database, real commands and a fake smtp server.
+type harness struct {
+	t          *testing.T
+	root       string
+	commandLog string // every command invocation appends a line to it
+	smtp       *smtptest.Server
+	config     *archive.AppConfig
+	engine     *archive.Engine
+}
+
+func newHarness(t *testing.T) *harness {
+	dir := t.TempDir()
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	h := &harness{
+		t:          t,
+		root:       filepath.Join(dir, "storage"),
+		commandLog: filepath.Join(dir, "commands.log"),
+		smtp:       server,
+	}
+	config := archive.DefaultConfig()
+	config.DB = filepath.Join(dir, "archive.db")
+	config.ServerName = "test server"
+	config.Root = h.root
+	config.EmailTo = "admin@example.org"
+	config.SmtpHost = server.Host()
+	config.SmtpPort = server.Port()
+	config.SmtpUser = "archive@example.org"
+	config.SmtpPassword = "secret"
+	config.LogFolder = filepath.Join(dir, "log")
+	config.PidFile = ""
+	// folders with "locked" in the path can't be archived
+	config.ArchiveCommand = `sh -c "case ${path} in *locked*) exit 3;; esac; echo archive ${id} >> ` + h.commandLog + ` && rm -rf ${path}"`
+	config.BackupCommand = `sh -c "echo backup ${id} $(wc -l < ${file}) >> ` + h.commandLog + `"`
+	h.config = config
+	store, err := archive.OpenBoltStore(config.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	h.engine = archive.NewEngine(config, store)
+	h.engine.SetCommandLogFolder(dir)
+	return h
+}
+
+// addDataset creates a dataset folder with the files, everything in it is modified days ago.
+// If id is not empty, a .datasetinfo file with the id is created.
+func (h *harness) addDataset(relPath string, id string, days int, files ...string) string {
+	path := filepath.Join(h.root, relPath)
+	for _, file := range files {
+		filePath := filepath.Join(path, file)
+		if err := os.MkdirAll(filepath.Dir(filePath), archive.FolderModeCreate); err != nil {
+			h.t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(file), archive.FileModeCreate); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	if id != "" {
+		err := archive.SaveDatasetInfo(archive.OSFS{}, path, &archive.Datasetinfo{ID: id})
+		if err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	modTime := time.Now().AddDate(0, 0, -days)
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(p, modTime, modTime)
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return path
+}
+
+func (h *harness) commands() []string {
+	data, err := ioutil.ReadFile(h.commandLog)
+	if err != nil && !os.IsNotExist(err) {
+		h.t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func (h *harness) inspect() *archive.InspectResult {
+	result, err := h.engine.Inspect()
 	if err != nil {
-		t.Error(err)
+		h.t.Fatal(err)
+	}
+	return result
+}
+
+// split the html report into its sections, by the <h1> titles
+func reportSections(body string) map[string]string {
+	sections := map[string]string{}
+	for _, part := range strings.Split(body, "<h1>")[1:] {
+		end := strings.Index(part, "</h1>")
+		if end < 0 {
+			continue
+		}
+		sections[part[:end]] = part[end+len("</h1>"):]
+	}
+	return sections
+}
+
+func findRecord(records []archive.DatasetRecord, path string) *archive.DatasetRecord {
+	for i := range records {
+		if records[i].Path == path {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+func TestAutoArchive(t *testing.T) {
+	h := newHarness(t)
+	oldPath := h.addDataset("krios/user1/old", "old-id", 40, "frames/1.tif")
+	stalePath := h.addDataset("krios/user1/stale", "stale-id", 25, "frames/1.tif", "info.txt")
+	lockedPath := h.addDataset("krios/user1/locked", "locked-id", 40, "frames/1.tif")
+	freshPath := h.addDataset("krios/user2/fresh", "", 0, "frames/1.tif")
+
+	autoArchive(h.engine)
+
+	// emails
+	messages := h.smtp.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(messages))
+	}
+	msg := messages[0]
+	if msg.From != "archive@example.org" || msg.User != "archive@example.org" {
+		t.Errorf("unexpected sender %q or user %q", msg.From, msg.User)
+	}
+	if len(msg.To) != 1 || msg.To[0] != "admin@example.org" {
+		t.Errorf("unexpected recipients %v", msg.To)
+	}
+	if !strings.HasPrefix(msg.Subject, "Archive report test server ") {
+		t.Errorf("unexpected subject %q", msg.Subject)
+	}
+	sections := reportSections(msg.Body)
+	expectedSections := map[string]string{
+		// the dataset is found late, the 10 days notice is skipped
+		"Directories to be archived": "<p>" + stalePath + " will be archived in 5 days, it will free 20 B</p>",
+		"Directories archived today": "<p>" + oldPath + " (12 B)</p>",
+		"Errors":                     "<p>folder: " + lockedPath + " error: exit status 3</p>",
+	}
+	for title, expected := range expectedSections {
+		if strings.TrimSpace(sections[title]) != expected {
+			t.Errorf("section %q is %q, expected %q", title, sections[title], expected)
+		}
 	}
-	failed := appConfig.DB != "archive.db"
-	failed = failed || appConfig.ScanLevel != 3
-	failed = failed || appConfig.ArchiveInterval != 30
-	failed = failed || appConfig.ArchiveCommand != "rm -rf \"${path}\""
-	failed = failed || appConfig.LogFolder != "log"
-	failed = failed || appConfig.Cores != 4
-	if failed {
-		t.Errorf("config value not correct: \n%v", appConfig)
+
+	// database
+	result := h.inspect()
+	if len(result.Archived) != 1 || result.Archived[0].ID != "old-id" || result.Archived[0].Path != oldPath {
+		t.Errorf("unexpected archived records %+v", result.Archived)
+	}
+	if len(result.Active) != 3 {
+		t.Errorf("expected 3 active records, got %+v", result.Active)
+	}
+	if r := findRecord(result.Active, stalePath); r == nil || r.ID != "stale-id" || r.NoticedLeftDays != 5 {
+		t.Errorf("unexpected record of the noticed dataset %+v", r)
+	}
+	if r := findRecord(result.Active, stalePath); r == nil || r.Stats.Bytes != 20 || r.Stats.Files != 2 || r.Stats.Dirs != 1 ||
+		len(r.Stats.LargestFiles) != 2 || r.Stats.LargestFiles[0].Path != "frames/1.tif" || r.Stats.Allocated == 0 {
+		t.Errorf("unexpected stats of the noticed dataset %+v", r)
+	}
+	if r := findRecord(result.Active, lockedPath); r == nil || r.ID != "locked-id" || r.NoticedLeftDays != 0 {
+		t.Errorf("unexpected record of the locked dataset %+v", r)
+	}
+	fresh := findRecord(result.Active, freshPath)
+	if fresh == nil || !fresh.ScanTime.Valid {
+		t.Fatalf("the new dataset is not recorded and scanned %+v", fresh)
+	}
+	info, err := archive.ReadDatasetinfo(archive.OSFS{}, freshPath)
+	if err != nil || info.ID != fresh.ID || !info.BackupTime.Valid {
+		t.Errorf("unexpected .datasetinfo of the new dataset %+v, error %v", info, err)
+	}
+
+	// commands
+	expectedCommands := []string{
+		"archive old-id",
+		"backup " + fresh.ID + " 1",
+		"backup stale-id 2",
+	}
+	sort.Strings(expectedCommands)
+	if commands := h.commands(); strings.Join(commands, "\n") != strings.Join(expectedCommands, "\n") {
+		t.Errorf("commands:\n%s\nexpected:\n%s", strings.Join(commands, "\n"), strings.Join(expectedCommands, "\n"))
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("archived folder %s still exists", oldPath)
+	}
+	if snapshots, err := os.ReadDir(h.config.SnapshotFolder()); err != nil || len(snapshots) != 1 {
+		t.Errorf("expected a snapshot of the database before the run, got %v, error %v", snapshots, err)
+	}
+
+	// a second run on the same day only reports the error again
+	autoArchive(h.engine)
+	messages = h.smtp.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 emails, got %d", len(messages))
+	}
+	sections = reportSections(messages[1].Body)
+	if strings.TrimSpace(sections["Directories to be archived"]) != "" || strings.TrimSpace(sections["Directories archived today"]) != "" {
+		t.Errorf("unexpected second report %q", messages[1].Body)
+	}
+	if !strings.Contains(sections["Errors"], lockedPath) {
+		t.Errorf("the second report doesn't contain the error %q", messages[1].Body)
+	}
+	if commands := h.commands(); len(commands) != len(expectedCommands) {
+		t.Errorf("unexpected commands in the second run %v", commands)
+	}
+}
+
+func TestUsageReportEmail(t *testing.T) {
+	h := newHarness(t)
+	h.addDataset("krios/user1/old", "old-id", 40, "frames/1.tif")
+	h.config.UsageReportTo = "pi@example.org"
+	h.engine.Notifier = archive.NewEmailNotifier(h.config)
+	autoArchive(h.engine)
+	if err := h.engine.SendUsageReport(); err != nil {
+		t.Fatal(err)
+	}
+	messages := h.smtp.Messages()
+	msg := messages[len(messages)-1]
+	if len(msg.To) != 1 || msg.To[0] != "pi@example.org" || !strings.HasPrefix(msg.Subject, "Usage report test server ") {
+		t.Errorf("unexpected usage report email to %v: %s", msg.To, msg.Subject)
+	}
+	if !strings.Contains(msg.Body, "<h2>Owners</h2>") {
+		t.Errorf("unexpected usage report:\n%s", msg.Body)
 	}
 }
 
-func TestDoArchive(t *testing.T) {
-	err := execArchiveCommand("./config-test.yml", "test", "echo \"${path}\"")
+func TestRunAutoArchiveError(t *testing.T) {
+	h := newHarness(t)
+	h.addDataset("krios/user1/old", "old-id", 40, "frames/1.tif")
+	// the scan fails without the database, the daemon logs it and keeps running
+	h.engine.Store.Close()
+	if err := runAutoArchive(h.engine); err == nil || !strings.Contains(err.Error(), "error in scan records") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if messages := h.smtp.Messages(); len(messages) != 0 {
+		t.Errorf("unexpected emails %v", messages)
+	}
+}
+
+func TestMigrateDB(t *testing.T) {
+	dir := t.TempDir()
+	boltFile := filepath.Join(dir, "archive.db")
+	store, err := archive.OpenBoltStore(boltFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddRecord(&archive.DatasetRecord{ID: "a", Path: "/storage/a"}); err != nil {
+		t.Fatal(err)
+	}
+	config := archive.DefaultConfig()
+	config.DBBackend = archive.BackendSQLite
+	config.DB = filepath.Join(dir, "archive.sqlite")
+	// the source is used by a run, the migration gives up instead of waiting for ever
+	if code := migrateDB(config, boltFile, 10*time.Millisecond); code != 1 {
+		t.Errorf("migrated a database used by another process, exit code %d", code)
+	}
+	store.Close()
+	// the database of the config is not its own source
+	same := *config
+	same.DB = filepath.Join(dir, ".", "archive.db")
+	if code := migrateDB(&same, boltFile, time.Second); code != 1 {
+		t.Errorf("migrated the database into itself, exit code %d", code)
+	}
+	if code := migrateDB(config, boltFile, time.Second); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	migrated, err := archive.OpenStore(config)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+	if r, _ := migrated.GetRecord("a"); r == nil {
+		t.Errorf("the record is not migrated")
 	}
 }
 
-// func TestSendNotice(t *testing.T) {
-// 	var scanResult ScanResult = ScanResult{
-// 		Errors: []ScanError{
-// 			{Path: "/test1", Msg: "err1"},
-// 			{Path: "/test2", Msg: "err2"},
-// 		},
-// 		Notices: []ArchiveNotice{
-// 			{
-// 				Path:              "/test3",
-// 				DaysBeforeArchive: 10,
-// 			},
-// 			{
-// 				Path:              "/test4",
-// 				DaysBeforeArchive: 5,
-// 			},
-// 		},
-// 		ArchivedFolders: []ArchivedFolder{
-// 			{
-// 				Path: "/test5",
-// 			},
-// 			{
-// 				Path: "/test6",
-// 			},
-// 		},
-// 	}
-// 	err := sendNoticeInternal(&scanResult, &EmailConfig{
-// 		ServerName: "test server",
-// 		Host:       "smtp-mail.outlook.com",
-// 		Port:       587,
-// 		From:       "rubsak1@outlook.com",
-// 		To:         "tianming.yi@med.uni-goettingen.de",
-// 		User:       "rubsak1@outlook.com",
-// 		Password:   "efndkubpkaksfmsx",
-// 	})
-// 	if err != nil {
-// 		t.Error(err)
-// 	}
-// }
+func TestBackupMissingSQLite(t *testing.T) {
+	dir := t.TempDir()
+	config := archive.DefaultConfig()
+	config.DBBackend = archive.BackendSQLite
+	config.DB = filepath.Join(dir, "typo.sqlite")
+	if code := backupDB(config, filepath.Join(dir, "copy.sqlite"), time.Second); code != 1 {
+		t.Errorf("a missing database is backed up, exit code %d", code)
+	}
+	if _, err := os.Stat(config.DB); !os.IsNotExist(err) {
+		t.Errorf("the missing database is created")
+	}
+}
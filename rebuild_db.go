@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"rubenlab.org/autoarchive/archive"
+)
+
+// rebuildDB recreates a lost database from the .datasetinfo files under the roots
+func rebuildDB(args []string) int {
+	flags := flag.NewFlagSet("rebuild-db", flag.ExitOnError)
+	archived := flags.Bool("archived", false, "recreate the archived records from the logs of the succeeded archive commands in log-folder, not from the backups")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive rebuild-db [-archived] config.yml")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	configFile := flags.Arg(0)
+	if configFile == "" {
+		flags.Usage()
+		return 2
+	}
+	config, store, code := openStore(configFile)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+	logFolder := ""
+	if *archived {
+		if config.LogFolder == "" {
+			fmt.Fprintln(os.Stderr, "log-folder is empty, the archived records can't be recreated")
+			return 1
+		}
+		logFolder = config.LogFolder
+	}
+	result, err := archive.NewEngine(config, store).Rebuild(logFolder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to rebuild the database, err: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%d active records and %d archived records recreated\n", result.Active, result.Archived)
+	for _, problem := range result.Problems {
+		fmt.Printf("problem: %s\n", problem)
+	}
+	if len(result.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
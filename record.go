@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"rubenlab.org/autoarchive/archive"
+)
+
+// showRecord prints the records of a dataset id or path, found by the path index
+func showRecord(args []string) int {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive record config.yml id|path")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	configFile := flags.Arg(0)
+	key := flags.Arg(1)
+	if configFile == "" || key == "" {
+		flags.Usage()
+		return 2
+	}
+	config, store, code := openStore(configFile)
+	if store == nil {
+		return code
+	}
+	defer store.Close()
+	records, err := archive.NewEngine(config, store).FindRecords(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to find the record, err: %v\n", err)
+		return 1
+	}
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "no record of %s\n", key)
+		return 1
+	}
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to print the record, err: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(b))
+	if len(records) > 1 {
+		fmt.Fprintf(os.Stderr, "%d records claim %s, delete the wrong ones\n", len(records), key)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"rubenlab.org/autoarchive/archive"
+)
+
+// usageReport prints the storage usage per owner and per group, or emails it
+func usageReport(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	format := flags.String("format", "text", "output format: "+strings.Join(archive.ReportFormats, ", "))
+	sendEmail := flags.Bool("email", false, "email the report to usage-report-to instead of printing it")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive report [-format text|csv|json|html] [-email] config.yml")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	configFile := flags.Arg(0)
+	if configFile == "" {
+		flags.Usage()
+		return 2
+	}
+	config, err := archive.LoadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't load config, err: %v\n", err)
+		return 1
+	}
+	store, err := archive.OpenStore(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open database, err: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+	engine := archive.NewEngine(config, store)
+	if *sendEmail {
+		err = engine.SendUsageReport()
+	} else {
+		var report *archive.UsageReport
+		report, err = engine.UsageReport()
+		if err == nil {
+			err = report.Write(os.Stdout, *format)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to report, err: %v\n", err)
+		return 1
+	}
+	return 0
+}
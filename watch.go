@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"rubenlab.org/autoarchive/archive"
+)
+
+// how often the changes followed by the watcher are saved
+const watchFlushInterval = time.Minute
+
+// watchDaemon runs auto archive every day and follows the changes of the datasets between the runs
+func watchDaemon(args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	runAt := flags.String("run-at", "02:00", "time of the daily run, HH:MM")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: autoarchive watch [-run-at HH:MM] config.yml")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	configFile := flags.Arg(0)
+	at, err := time.Parse("15:04", *runAt)
+	if configFile == "" || err != nil {
+		flags.Usage()
+		return 2
+	}
+	config, err := archive.LoadConfig(configFile)
+	if err != nil {
+		log.Printf("can't load config, err: %v", err)
+		return 1
+	}
+	store, err := archive.OpenStore(config)
+	if err != nil {
+		log.Printf("can't init db, error: %v", err)
+		return 1
+	}
+	defer store.Close()
+	engine := archive.NewEngine(config, store)
+
+	// the daemon holds the pid lock, the runs from cron are skipped
+	pidLock, lockErr := tryLock(config)
+	if lockErr != nil {
+		log.Println("failed to get lock, other autoarchive process is running")
+		return 1
+	}
+	defer func() {
+		if pidLock != nil {
+			pidLock.Unlock()
+		}
+	}()
+	if backups := serveBackups(engine); backups != nil {
+		defer backups.Close()
+	}
+
+	watcher, err := engine.StartWatcher()
+	if watcher == nil {
+		log.Printf("can't watch the datasets, they are only scanned by the daily runs, error: %v", err)
+	} else if err != nil {
+		log.Printf("error watching the datasets: %v", err)
+	}
+
+	var logCloser io.Closer
+	defer func() {
+		if logCloser != nil {
+			logCloser.Close()
+		}
+	}()
+	run := func() {
+		// a new log folder every day
+		if logCloser != nil {
+			logCloser.Close()
+		}
+		var logOutputFolder string
+		logOutputFolder, logCloser, err = initLog(config)
+		if err != nil {
+			log.Printf("init log error, error is: %v", err)
+		}
+		engine.SetCommandLogFolder(logOutputFolder)
+		log.Println("start auto archive")
+		// a failed run is tried again the next day, the daemon keeps running
+		if err := runAutoArchive(engine); err != nil {
+			log.Println(err)
+		}
+		log.Println("finish auto archive")
+		// watch the datasets found by the run
+		if watcher != nil {
+			if err := watcher.Sync(); err != nil {
+				log.Printf("error watching the datasets: %v", err)
+			}
+		}
+	}
+	run()
+	flushTicker := time.NewTicker(watchFlushInterval)
+	defer flushTicker.Stop()
+	runTimer := time.NewTimer(time.Until(nextRun(time.Now(), at)))
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	flush := func() {
+		if watcher == nil {
+			return
+		}
+		if err := watcher.Flush(); err != nil {
+			log.Printf("error saving the watched changes: %v", err)
+		}
+	}
+	for {
+		select {
+		case <-flushTicker.C:
+			flush()
+		case <-runTimer.C:
+			flush()
+			run()
+			runTimer.Reset(time.Until(nextRun(time.Now(), at)))
+		case sig := <-stop:
+			log.Printf("stop watching, signal %v", sig)
+			flush()
+			if watcher != nil {
+				watcher.Close()
+			}
+			return 0
+		}
+	}
+}
+
+// the next time of the day at, after now
+func nextRun(now time.Time, at time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}